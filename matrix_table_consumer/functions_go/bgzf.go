@@ -0,0 +1,266 @@
+package functions_go
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+func openBGZF(path string) (*bgzfReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the file: %v", err)
+	}
+
+	return &bgzfReader{
+		file:     f,
+		reader:   bufio.NewReader(f),
+		inflater: flate.NewReader(bytes.NewReader(nil)),
+	}, nil
+}
+
+// isBGZF checks that the file starts with a BGZF block
+func isBGZF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return header[0] == 31 && header[1] == 139 && header[3]&4 != 0 && header[12] == 'B' && header[13] == 'C'
+}
+
+// readBlock reads and decompresses the next block, io.EOF is returned at the end of the file
+func (b *bgzfReader) readBlock() error {
+	b.blockOffset = b.nextBlockOffset
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(b.reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("truncated BGZF block at offset %d", b.blockOffset)
+		}
+		return err
+	}
+	if header[0] != 31 || header[1] != 139 || header[3]&4 == 0 {
+		return fmt.Errorf("invalid BGZF block at offset %d", b.blockOffset)
+	}
+
+	xlen := int(binary.LittleEndian.Uint16(header[10:12]))
+	extra := make([]byte, xlen)
+	if _, err := io.ReadFull(b.reader, extra); err != nil {
+		return fmt.Errorf("truncated BGZF block at offset %d", b.blockOffset)
+	}
+
+	blockSize := -1
+	for i := 0; i+4 <= xlen; {
+		length := int(binary.LittleEndian.Uint16(extra[i+2 : i+4]))
+		if extra[i] == 'B' && extra[i+1] == 'C' && length == 2 && i+6 <= xlen {
+			blockSize = int(binary.LittleEndian.Uint16(extra[i+4:i+6])) + 1
+		}
+		i += 4 + length
+	}
+	if blockSize < 0 {
+		return fmt.Errorf("BGZF block size not found at offset %d", b.blockOffset)
+	}
+
+	compressed := make([]byte, blockSize-xlen-20)
+	if _, err := io.ReadFull(b.reader, compressed); err != nil {
+		return fmt.Errorf("truncated BGZF block at offset %d", b.blockOffset)
+	}
+	trailer := make([]byte, 8)
+	if _, err := io.ReadFull(b.reader, trailer); err != nil {
+		return fmt.Errorf("truncated BGZF block at offset %d", b.blockOffset)
+	}
+
+	data := make([]byte, binary.LittleEndian.Uint32(trailer[4:8]))
+	if err := b.inflater.(flate.Resetter).Reset(bytes.NewReader(compressed), nil); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(b.inflater, data); err != nil {
+		return fmt.Errorf("failed to decompress BGZF block at offset %d: %v", b.blockOffset, err)
+	}
+
+	b.data = data
+	b.pos = 0
+	b.nextBlockOffset = b.blockOffset + int64(blockSize)
+	b.bytesRead += int64(blockSize)
+	return nil
+}
+
+// Seek moves to a virtual offset: the offset of the block in the file << 16 | the offset in the block
+func (b *bgzfReader) Seek(offset uint64) error {
+	blockOffset := int64(offset >> 16)
+	if _, err := b.file.Seek(blockOffset, io.SeekStart); err != nil {
+		return err
+	}
+	b.reader.Reset(b.file)
+	b.nextBlockOffset = blockOffset
+
+	if err := b.readBlock(); err != nil {
+		return err
+	}
+	b.pos = min(int(offset&0xffff), len(b.data))
+	return nil
+}
+
+// Tell returns the virtual offset of the next byte
+func (b *bgzfReader) Tell() uint64 {
+	if b.pos >= len(b.data) {
+		return uint64(b.nextBlockOffset) << 16
+	}
+	return uint64(b.blockOffset)<<16 | uint64(b.pos)
+}
+
+// ReadLine reads a line without the line break
+func (b *bgzfReader) ReadLine() (string, error) {
+	var line []byte
+	for {
+		if b.pos >= len(b.data) {
+			if err := b.readBlock(); err != nil {
+				if err == io.EOF && len(line) > 0 {
+					return string(line), nil
+				}
+				return "", err
+			}
+			continue
+		}
+
+		if i := bytes.IndexByte(b.data[b.pos:], '\n'); i >= 0 {
+			line = append(line, b.data[b.pos:b.pos+i]...)
+			b.pos += i + 1
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		line = append(line, b.data[b.pos:]...)
+		b.pos = len(b.data)
+	}
+}
+
+func (b *bgzfReader) Close() error {
+	b.inflater.Close()
+	return b.file.Close()
+}
+
+// bgzfBlockSize is the size of uncompressed data in a block, the same as in htslib
+const bgzfBlockSize = 0xff00
+
+// bgzfEOF is the empty block written at the end of BGZF files
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43,
+	0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+func createBGZF(path string) (*bgzfWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return newBGZFWriter(f)
+}
+
+// appendBGZF opens a BGZF file to write blocks after its end, the EOF marker of the file
+// stays in the middle as an empty block, which readers skip
+func appendBGZF(path string) (*bgzfWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	return newBGZFWriter(f)
+}
+
+func newBGZFWriter(f *os.File) (*bgzfWriter, error) {
+	deflater, err := flate.NewWriter(nil, flate.DefaultCompression)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &bgzfWriter{
+		file:     f,
+		deflater: deflater,
+		data:     make([]byte, 0, bgzfBlockSize),
+	}, nil
+}
+
+// Write buffers the data and writes full blocks
+func (b *bgzfWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), bgzfBlockSize-len(b.data))
+		b.data = append(b.data, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(b.data) == bgzfBlockSize {
+			if err := b.writeBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeBlock compresses the buffered data into a block, data that does not shrink is stored
+func (b *bgzfWriter) writeBlock() error {
+	if len(b.data) == 0 {
+		return nil
+	}
+
+	if err := b.compress(b.deflater); err != nil {
+		return err
+	}
+	// 18 bytes of the header and 8 bytes of the trailer must fit into 64 KB
+	if b.compressed.Len()+26 > 1<<16 {
+		stored, err := flate.NewWriter(nil, flate.NoCompression)
+		if err != nil {
+			return err
+		}
+		if err := b.compress(stored); err != nil {
+			return err
+		}
+	}
+
+	header := []byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 6, 0, 'B', 'C', 2, 0, 0, 0}
+	binary.LittleEndian.PutUint16(header[16:], uint16(b.compressed.Len()+25))
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[0:], crc32.ChecksumIEEE(b.data))
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(len(b.data)))
+
+	for _, part := range [][]byte{header, b.compressed.Bytes(), trailer} {
+		if _, err := b.file.Write(part); err != nil {
+			return err
+		}
+	}
+	b.data = b.data[:0]
+	return nil
+}
+
+func (b *bgzfWriter) compress(deflater *flate.Writer) error {
+	b.compressed.Reset()
+	deflater.Reset(&b.compressed)
+	if _, err := deflater.Write(b.data); err != nil {
+		return err
+	}
+	return deflater.Close()
+}
+
+// Close writes the last block and the EOF marker
+func (b *bgzfWriter) Close() error {
+	if err := b.writeBlock(); err != nil {
+		b.file.Close()
+		return err
+	}
+	if _, err := b.file.Write(bgzfEOF); err != nil {
+		b.file.Close()
+		return err
+	}
+	return b.file.Close()
+}
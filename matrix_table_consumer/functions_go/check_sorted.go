@@ -0,0 +1,109 @@
+package functions_go
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CheckSorted checks that a VCF file is sorted by contig in the order of the reference (.fai or .dict)
+// if reference_path is set, otherwise of the ##contig header lines and the natural order, then by position.
+// Records at the same position that are not in the order of Sort (REF and ALT) are reported as a note,
+// they do not make the file unsorted. The report is returned in JSON format
+func CheckSorted(input_vcf string, reference_path string) string {
+	report := &SortCheckReport{}
+	fail := func(s string) string {
+		LoggerError(s)
+		report.Error = strings.TrimSpace(s)
+		return reportJSON(report)
+	}
+
+	order := NewContigOrder()
+	if reference_path != "" {
+		var err error
+		if order, err = LoadContigOrder(reference_path); err != nil {
+			return fail(fmt.Sprintf("Error reading contig order: %v\n", err))
+		}
+	}
+
+	reader, err := OpenVCF(input_vcf)
+	if err != nil {
+		return fail(fmt.Sprintf("Error opening input file: %v\n", err))
+	}
+	defer reader.Close()
+
+	seenContigs := make(map[string]bool)
+	var previous VCFRecord
+	var lineNumber int64 = 0
+
+	progress := NewProgress("check sorted", reader.Size)
+	scanner := GetScaner(reader.Reader)
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			order.ParseHeaderLine(line)
+			continue
+		}
+
+		record, ok := parseRecord(line, order, false)
+		if !ok {
+			continue
+		}
+		progress.Update(1, reader.BytesRead())
+
+		unsorted := false
+		if record.Chromosome != previous.Chromosome && seenContigs[record.Chromosome] {
+			report.InterleavedContigs = true
+			unsorted = true
+		} else if previous.Chromosome != "" && comparePositions(&record, &previous) < 0 {
+			unsorted = true
+		} else if previous.Chromosome != "" && compareRecords(&record, &previous) < 0 && report.TieOrder == nil {
+			report.TieOrder = &UnsortedRecord{
+				Line:     lineNumber,
+				Previous: describeSortKey(&previous, &record),
+				Record:   describeSortKey(&record, &previous),
+			}
+		}
+		seenContigs[record.Chromosome] = true
+
+		if unsorted && report.FirstUnsorted == nil {
+			report.FirstUnsorted = &UnsortedRecord{
+				Line:     lineNumber,
+				Previous: describeSortKey(&previous, &record),
+				Record:   describeSortKey(&record, &previous),
+			}
+		}
+		previous = record
+
+		// Nothing else can be found
+		if report.InterleavedContigs {
+			break
+		}
+	}
+	progress.Close()
+
+	if err := scanner.Err(); err != nil {
+		return fail(fmt.Sprintf("Error reading input: %v\n", err))
+	}
+
+	report.Sorted = report.FirstUnsorted == nil
+	if !report.Sorted {
+		s := fmt.Sprintf("%s is not sorted: %s after %s at line %d\n", input_vcf,
+			report.FirstUnsorted.Record, report.FirstUnsorted.Previous, report.FirstUnsorted.Line)
+		LoggerInfo(s)
+	} else if report.TieOrder != nil {
+		s := fmt.Sprintf("%s is sorted, but the records at the same position are not in the order of Sort: %s after %s at line %d\n",
+			input_vcf, report.TieOrder.Record, report.TieOrder.Previous, report.TieOrder.Line)
+		LoggerInfo(s)
+	}
+	return reportJSON(report)
+}
+
+// describeSortKey returns chrom:pos of the record, REF and ALT are added
+// if the other record is at the same position
+func describeSortKey(record *VCFRecord, other *VCFRecord) string {
+	if record.Chromosome != other.Chromosome || record.Position != other.Position {
+		return fmt.Sprintf("%s:%d", record.Chromosome, record.Position)
+	}
+	return fmt.Sprintf("%s:%d %s>%s", record.Chromosome, record.Position, record.ref, record.alt)
+}
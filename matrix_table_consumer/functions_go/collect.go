@@ -11,39 +11,27 @@ import (
 	"sync"
 )
 
-func Collect(num_rows int, start_row int, vcf_path string, num_cpu int) string {
+// Collect returns num_rows rows starting from start_row, only the rows overlapping
+// the regions (or the BED file regions_file) are counted if they are set
+func Collect(num_rows int, start_row int, vcf_path string, num_cpu int, regions string, regions_file string) string {
 	if num_cpu <= 0 {
 		num_cpu = 1
 	}
 
-	var reader *bufio.Reader
-
-	if strings.HasSuffix(vcf_path, ".gz") {
-		f, err := os.Open(vcf_path)
-		if err != nil {
-			s := fmt.Sprintf("Failed to open the file: %v\n", err)
-			LoggerError(s)
-		}
-		defer f.Close()
-
-		gr, err := gzip.NewReader(f)
-		if err != nil {
-			s := fmt.Sprintf("Error creating gzip reader: %v\n", err)
-			LoggerError(s)
-		}
-		defer gr.Close()
-
-		reader = bufio.NewReader(gr)
-	} else {
-		f, err := os.Open(vcf_path)
-		if err != nil {
-			s := fmt.Sprintf("Failed to open the file: %v\n", err)
-			LoggerError(s)
-		}
-		defer f.Close()
+	regionSet, err := LoadRegions(regions, regions_file)
+	if err != nil {
+		s := fmt.Sprintf("Error reading regions: %v\n", err)
+		LoggerError(s)
+		return "[]"
+	}
 
-		reader = bufio.NewReader(f)
+	reader, err := OpenVCFRegions(vcf_path, regionSet)
+	if err != nil {
+		s := fmt.Sprintf("%v\n", err)
+		LoggerError(s)
+		return "[]"
 	}
+	defer reader.Close()
 
 	flag := false
 	rows := make([]*VCFRowJSON, 0)
@@ -60,10 +48,9 @@ func Collect(num_rows int, start_row int, vcf_path string, num_cpu int) string {
 		go ParallelExtractRows(linesChan, &wg, resultsChan)
 	}
 
-	bar := NewTqdm(num_rows, WithDescription("Collecting data"))
-	scanner := GetScaner(reader)
-	for scanner.Scan() {
-		if strings.HasPrefix(scanner.Text(), "#") {
+	progress := NewProgress("collect", reader.Size).WithBar(num_rows, WithDescription("Collecting data"))
+	for reader.Scan() {
+		if strings.HasPrefix(reader.Text(), "#") {
 			continue
 		}
 
@@ -71,14 +58,14 @@ func Collect(num_rows int, start_row int, vcf_path string, num_cpu int) string {
 			flag = false
 			break
 		} else if flag {
-			line := scanner.Text()
+			line := reader.Text()
 			linesChan <- line
-			bar.Increment()
+			progress.Update(1, reader.BytesRead())
 		} else if start_row == rows_count {
 			flag = true
-			line := scanner.Text()
+			line := reader.Text()
 			linesChan <- line
-			bar.Increment()
+			progress.Update(1, reader.BytesRead())
 		}
 
 		if num == 200_000 {
@@ -95,13 +82,13 @@ func Collect(num_rows int, start_row int, vcf_path string, num_cpu int) string {
 		rows_count += 1
 		num += 1
 	}
-	bar.Close()
+	progress.Close()
 
 	close(linesChan)
 	wg.Wait()
 	close(resultsChan)
 
-	if err := scanner.Err(); err != nil {
+	if err := reader.Err(); err != nil {
 		s := fmt.Sprintf("Reading standard input: %v\n", err)
 		LoggerError(s)
 	}
@@ -124,34 +111,13 @@ func CollectAll(vcf_path string, num_cpu int) string {
 		num_cpu = 1
 	}
 
-	var reader *bufio.Reader
-
-	if strings.HasSuffix(vcf_path, ".gz") {
-		f, err := os.Open(vcf_path)
-		if err != nil {
-			s := fmt.Sprintf("Failed to open the file: %v\n", err)
-			LoggerError(s)
-		}
-		defer f.Close()
-
-		gr, err := gzip.NewReader(f)
-		if err != nil {
-			s := fmt.Sprintf("Error creating gzip reader: %v\n", err)
-			LoggerError(s)
-		}
-		defer gr.Close()
-
-		reader = bufio.NewReader(gr)
-	} else {
-		f, err := os.Open(vcf_path)
-		if err != nil {
-			s := fmt.Sprintf("Failed to open the file: %v\n", err)
-			LoggerError(s)
-		}
-		defer f.Close()
-
-		reader = bufio.NewReader(f)
+	reader, err := OpenVCF(vcf_path)
+	if err != nil {
+		s := fmt.Sprintf("%v\n", err)
+		LoggerError(s)
+		return "[]"
 	}
+	defer reader.Close()
 
 	// Channels for transmitting strings and results
 	linesChan := make(chan string, 100_000)
@@ -168,7 +134,8 @@ func CollectAll(vcf_path string, num_cpu int) string {
 		go ParallelExtractRows(linesChan, &wg, resultsChan)
 	}
 
-	scanner := GetScaner(reader)
+	progress := NewProgress("collect_all", reader.Size)
+	scanner := GetScaner(reader.Reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.HasPrefix(line, "#") {
@@ -187,6 +154,7 @@ func CollectAll(vcf_path string, num_cpu int) string {
 		}
 
 		linesChan <- line
+		progress.Update(1, reader.BytesRead())
 
 		if rows_count%50_000 == 0 && rows_count != 0 {
 			s := fmt.Sprintf("%d lines read\n", rows_count)
@@ -197,6 +165,7 @@ func CollectAll(vcf_path string, num_cpu int) string {
 		rows_count += 1
 	}
 
+	progress.Close()
 	LoggerInfo("Waiting for channels...\n")
 
 	close(linesChan)
@@ -0,0 +1,114 @@
+package functions_go
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Ranks of the natural order: numbered contigs, then X, Y and MT, then the other contigs by name
+var naturalContigRanks = map[string]int{"X": 0, "Y": 1, "MT": 2, "M": 2}
+
+func NewContigOrder() *ContigOrder {
+	return &ContigOrder{index: make(map[string]int)}
+}
+
+// LoadContigOrder reads the contig order of the reference from a .fai index or a .dict sequence dictionary
+func LoadContigOrder(reference_path string) (*ContigOrder, error) {
+	isFai := strings.HasSuffix(reference_path, ".fai")
+	isDict := strings.HasSuffix(reference_path, ".dict")
+	if !isFai && !isDict {
+		return nil, fmt.Errorf("unknown contig order file %s, expected .fai or .dict", reference_path)
+	}
+
+	reader, err := OpenVCF(reference_path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	order := NewContigOrder()
+	order.fromReference = true
+	scanner := GetScaner(reader.Reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isFai {
+			name, _, _ := strings.Cut(line, "\t")
+			order.add(strings.TrimSpace(name))
+			continue
+		}
+
+		// @SQ	SN:chr1	LN:248956422
+		if !strings.HasPrefix(line, "@SQ") {
+			continue
+		}
+		for tag := range strings.SplitSeq(line, "\t") {
+			if name, found := strings.CutPrefix(tag, "SN:"); found {
+				order.add(name)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", reference_path, err)
+	}
+	if len(order.index) == 0 {
+		return nil, fmt.Errorf("no contigs in %s", reference_path)
+	}
+	return order, nil
+}
+
+func (o *ContigOrder) add(name string) {
+	if _, exists := o.index[name]; name != "" && !exists {
+		o.index[name] = len(o.index)
+	}
+}
+
+// ParseHeaderLine adds the contig of a ##contig line, the lines are ignored if the order comes from a reference
+func (o *ContigOrder) ParseHeaderLine(line string) {
+	if o.fromReference || !strings.HasPrefix(line, "##contig=<") {
+		return
+	}
+	o.add(parseHeaderField(line[len("##contig=<"):]).ID)
+}
+
+// Len returns the number of listed contigs
+func (o *ContigOrder) Len() int {
+	return len(o.index)
+}
+
+// Key returns the sort key of the contig: listed contigs come first in the order of the list,
+// the other contigs follow in the natural order
+func (o *ContigOrder) Key(chrom string) contigKey {
+	if index, exists := o.index[chrom]; exists {
+		return contigKey{listed: index}
+	}
+
+	key := contigKey{listed: math.MaxInt, name: chrom}
+	name := strings.ToUpper(chrom)
+	if len(name) > 3 && strings.HasPrefix(name, "CHR") {
+		name = name[3:]
+	}
+	if number, err := strconv.Atoi(name); err == nil && number >= 0 {
+		key.number = number
+	} else if rank, exists := naturalContigRanks[name]; exists {
+		key.group = 1
+		key.number = rank
+	} else {
+		key.group = 2
+	}
+	return key
+}
+
+func compareContigKeys(a, b contigKey) int {
+	switch {
+	case a.listed != b.listed:
+		return cmp.Compare(a.listed, b.listed)
+	case a.group != b.group:
+		return cmp.Compare(a.group, b.group)
+	case a.number != b.number:
+		return cmp.Compare(a.number, b.number)
+	}
+	return strings.Compare(a.name, b.name)
+}
@@ -0,0 +1,175 @@
+package functions_go
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Policies of Dedup: records with the same line, the same CHROM and POS, or the same CHROM, POS, REF and ALT
+const (
+	DedupExact   = "exact"
+	DedupSite    = "site"
+	DedupAlleles = "alleles"
+)
+
+// Records written by Dedup: the first duplicate or the one with the highest QUAL (the first one on ties)
+const (
+	KeepFirst       = "first"
+	KeepHighestQual = "qual"
+)
+
+func dedupReportJSON(report *DedupReport) string {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		s := fmt.Sprintf("JSON conversion error: %v\n", err)
+		LoggerError(s)
+		return "{}"
+	}
+	return string(data)
+}
+
+// dedupKey returns the key of the record, records with the same key are duplicates
+func dedupKey(line string, parts []string, policy string) string {
+	switch policy {
+	case DedupSite:
+		return parts[0] + "\t" + parts[1]
+	case DedupAlleles:
+		return strings.Join(parts[:2], "\t") + "\t" + parts[3] + "\t" + parts[4]
+	}
+	return line
+}
+
+// parseQual returns QUAL as a number, missing QUAL is lower than any value
+func parseQual(qual string) float64 {
+	value, err := strconv.ParseFloat(qual, 64)
+	if err != nil {
+		return math.Inf(-1)
+	}
+	return value
+}
+
+// Dedup removes duplicate records of a sorted VCF file. Duplicates have the same position, so only
+// the records of the current position are kept in memory. Records are written in the input order,
+// the report with the number of removed records is returned in JSON format
+func Dedup(options DedupOptions) string {
+	report := &DedupReport{}
+	fail := func(s string) string {
+		LoggerError(s)
+		report.Error = strings.TrimSpace(s)
+		return dedupReportJSON(report)
+	}
+
+	policy := options.Policy
+	if policy == "" {
+		policy = DedupExact
+	}
+	keep := options.Keep
+	if keep == "" {
+		keep = KeepFirst
+	}
+	if policy != DedupExact && policy != DedupSite && policy != DedupAlleles {
+		return fail(fmt.Sprintf("Unknown duplicate policy '%s', expected exact, site or alleles\n", policy))
+	}
+	if keep != KeepFirst && keep != KeepHighestQual {
+		return fail(fmt.Sprintf("Unknown keep rule '%s', expected first or qual\n", keep))
+	}
+
+	reader, err := OpenVCF(options.InputVCF)
+	if err != nil {
+		return fail(fmt.Sprintf("Error opening input file: %v\n", err))
+	}
+	defer reader.Close()
+
+	outputFile, err := os.Create(options.OutputVCF)
+	if err != nil {
+		return fail(fmt.Sprintf("Error creating file: %v\n", err))
+	}
+	defer outputFile.Close()
+
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	// Records of the current position, the index of each key in the group
+	var group []*dedupRecord
+	keys := make(map[string]int)
+	flush := func() {
+		for _, record := range group {
+			fmt.Fprintln(writer, record.line)
+			report.OutputRows++
+			if record.duplicates > 0 {
+				report.DuplicateGroups++
+				report.RemovedRows += int64(record.duplicates)
+			}
+		}
+		group = group[:0]
+		clear(keys)
+	}
+
+	seenContigs := make(map[string]bool)
+	var chrom string
+	var pos int64 = -1
+
+	progress := NewProgress("dedup", reader.Size)
+	scanner := GetScaner(reader.Reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			fmt.Fprintln(writer, line)
+			continue
+		}
+
+		parts := strings.SplitN(line, "\t", 7)
+		if len(parts) < 6 {
+			continue
+		}
+		recordPos, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		report.InputRows++
+		progress.Update(1, reader.BytesRead())
+
+		if parts[0] != chrom || recordPos != pos {
+			if parts[0] == chrom && recordPos < pos || parts[0] != chrom && seenContigs[parts[0]] {
+				progress.Close()
+				writer.Flush()
+				outputFile.Close()
+				os.Remove(options.OutputVCF)
+				return fail(fmt.Sprintf("Input is not sorted: %s:%d after %s:%d\n", parts[0], recordPos, chrom, pos))
+			}
+			flush()
+			seenContigs[parts[0]] = true
+			chrom, pos = parts[0], recordPos
+		}
+
+		record := &dedupRecord{line: line, key: dedupKey(line, parts, policy), qual: parseQual(parts[5])}
+		index, exists := keys[record.key]
+		if !exists {
+			keys[record.key] = len(group)
+			group = append(group, record)
+			continue
+		}
+
+		kept := group[index]
+		kept.duplicates++
+		if keep == KeepHighestQual && record.qual > kept.qual {
+			record.duplicates = kept.duplicates
+			group[index] = record
+		}
+	}
+	flush()
+	progress.Close()
+
+	if err := scanner.Err(); err != nil {
+		return fail(fmt.Sprintf("Error reading input: %v\n", err))
+	}
+
+	s := fmt.Sprintf("Removed %d duplicate records\n", report.RemovedRows)
+	LoggerInfo(s)
+	return dedupReportJSON(report)
+}
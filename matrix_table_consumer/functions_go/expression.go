@@ -0,0 +1,509 @@
+package functions_go
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	tokenEOF = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBracket
+	tokenRightBracket
+	tokenComma
+)
+
+type token struct {
+	value string
+	kind  int
+	pos   int
+}
+
+// operators are sorted so that longer operators are matched first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "!~", "=", "<", ">", "~", "&", "|", "!", "+", "-", "*", "/"}
+
+// tokenize splits an expression into tokens
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, token{value: "(", kind: tokenLeftParen, pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{value: ")", kind: tokenRightParen, pos: i})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{value: "[", kind: tokenLeftBracket, pos: i})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{value: "]", kind: tokenRightBracket, pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{value: ",", kind: tokenComma, pos: i})
+			i++
+
+		case r == '"' || r == '\'':
+			start := i
+			var value strings.Builder
+			i++
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{value: value.String(), kind: tokenString, pos: start})
+
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				j := i + 1
+				if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+					j++
+				}
+				if j < len(runes) && unicode.IsDigit(runes[j]) {
+					i = j
+					for i < len(runes) && unicode.IsDigit(runes[i]) {
+						i++
+					}
+				}
+			}
+			tokens = append(tokens, token{value: string(runes[start:i]), kind: tokenNumber, pos: start})
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			i = scanIdent(runes, i)
+			// INFO/AF, FMT/DP and FORMAT/DP are single identifiers
+			prefix := strings.ToUpper(string(runes[start:i]))
+			if (prefix == "INFO" || prefix == "FMT" || prefix == "FORMAT") && i+1 < len(runes) && runes[i] == '/' &&
+				(unicode.IsLetter(runes[i+1]) || runes[i+1] == '_' || unicode.IsDigit(runes[i+1])) {
+				i = scanIdent(runes, i+1)
+			}
+			tokens = append(tokens, token{value: string(runes[start:i]), kind: tokenIdent, pos: start})
+
+		default:
+			matched := false
+			for _, operator := range operators {
+				if strings.HasPrefix(string(runes[i:min(i+len(operator), len(runes))]), operator) {
+					tokens = append(tokens, token{value: operator, kind: tokenOperator, pos: i})
+					i += len(operator)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", r, i)
+			}
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(runes)})
+	return tokens, nil
+}
+
+func scanIdent(runes []rune, i int) int {
+	for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+		i++
+	}
+	return i
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) acceptOperator(operators ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return "", false
+	}
+	for _, operator := range operators {
+		if t.value == operator {
+			p.next()
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(kind int, value string) error {
+	t := p.next()
+	if t.kind != kind {
+		return unexpectedToken(t, value)
+	}
+	return nil
+}
+
+func unexpectedToken(t token, expected string) error {
+	if t.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of expression, expected %s", expected)
+	}
+	return fmt.Errorf("unexpected '%s' at position %d, expected %s", t.value, t.pos, expected)
+}
+
+// parseOr: and (("||" | "|") and)*
+func (p *parser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := p.acceptOperator("||", "|")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{left: left, right: right, operator: operator}
+	}
+}
+
+// parseAnd: comparison (("&&" | "&") comparison)*
+func (p *parser) parseAnd() (exprNode, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := p.acceptOperator("&&", "&")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{left: left, right: right, operator: operator}
+	}
+}
+
+// parseComparison: additive (comparison_operator additive)?
+func (p *parser) parseComparison() (exprNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	operator, ok := p.acceptOperator("==", "=", "!=", "<", "<=", ">", ">=", "~", "!~")
+	if !ok {
+		return left, nil
+	}
+	if operator == "=" {
+		operator = "=="
+	}
+
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	if operator == "~" || operator == "!~" {
+		pattern, ok := right.(*stringNode)
+		if !ok {
+			return nil, fmt.Errorf("regular expression at position %d must be a string", t.pos)
+		}
+		re, err := regexp.Compile(pattern.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression '%s': %v", pattern.value, err)
+		}
+		return &regexNode{left: left, re: re, negate: operator == "!~"}, nil
+	}
+
+	if operator == "==" || operator == "!=" {
+		if node := newGenotypeNode(left, right, operator == "!="); node != nil {
+			return node, nil
+		}
+	}
+
+	return &comparisonNode{left: left, right: right, operator: operator}, nil
+}
+
+// parseAdditive: multiplicative (("+" | "-") multiplicative)*
+func (p *parser) parseAdditive() (exprNode, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := p.acceptOperator("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmeticNode{left: left, right: right, operator: operator}
+	}
+}
+
+// parseMultiplicative: unary (("*" | "/") unary)*
+func (p *parser) parseMultiplicative() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := p.acceptOperator("*", "/")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmeticNode{left: left, right: right, operator: operator}
+	}
+}
+
+// parseUnary: ("!" | "-") unary | primary
+func (p *parser) parseUnary() (exprNode, error) {
+	if operator, ok := p.acceptOperator("!", "-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if operator == "-" {
+			if number, ok := operand.(*numberNode); ok {
+				return &numberNode{value: -number.value}, nil
+			}
+			return &arithmeticNode{left: &numberNode{value: 0}, right: operand, operator: "-"}, nil
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary: number | string | field | function "(" arguments ")" | "[" list "]" | "(" or ")"
+func (p *parser) parsePrimary() (exprNode, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at position %d", t.value, t.pos)
+		}
+		return &numberNode{value: value}, nil
+
+	case tokenString:
+		return &stringNode{value: t.value}, nil
+
+	case tokenLeftParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenRightParen, "')'"); err != nil {
+			return nil, err
+		}
+		return node, nil
+
+	case tokenLeftBracket:
+		return p.parseList()
+
+	case tokenIdent:
+		if p.peek().kind == tokenLeftParen {
+			return p.parseCall(t)
+		}
+		field := newFieldNode(t.value)
+		if p.peek().kind == tokenLeftBracket {
+			return p.parseIndex(field)
+		}
+		return field, nil
+	}
+
+	return nil, unexpectedToken(t, "a value")
+}
+
+func (p *parser) parseCall(name token) (exprNode, error) {
+	function, exists := FilterFunctions[name.value]
+	if !exists {
+		return nil, fmt.Errorf("unknown function '%s' at position %d", name.value, name.pos)
+	}
+	p.next()
+
+	var args []exprNode
+	if p.peek().kind != tokenRightParen {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if err := p.expect(tokenRightParen, "')'"); err != nil {
+		return nil, err
+	}
+
+	if err := function.checkArguments(name.value, args, nil); err != nil {
+		return nil, err
+	}
+	return &callNode{name: name.value, function: function, args: args}, nil
+}
+
+// parseIndex: field "[" sample "]", FMT/DP[0] is the depth of the first sample
+func (p *parser) parseIndex(field *fieldNode) (exprNode, error) {
+	bracket := p.next()
+	if field.scope == fieldSite {
+		return nil, fmt.Errorf("index at position %d is supported only for INFO and FORMAT fields", bracket.pos)
+	}
+
+	t := p.next()
+	index, err := strconv.Atoi(t.value)
+	if t.kind != tokenNumber || err != nil || index < 0 {
+		if field.scope == fieldInfo {
+			return nil, unexpectedToken(t, "a value index")
+		}
+		return nil, unexpectedToken(t, "a sample index")
+	}
+	if err := p.expect(tokenRightBracket, "']'"); err != nil {
+		return nil, err
+	}
+
+	// INFO/AF[1] is the value of the second ALT allele, FMT/DP[1] is the value of the second sample
+	if field.scope == fieldInfo {
+		return &valueIndexNode{field: field, index: index}, nil
+	}
+	return &sampleIndexNode{field: field, sample: index}, nil
+}
+
+// parseList: "[" (or ("," or)*)? "]"
+func (p *parser) parseList() (exprNode, error) {
+	list := &listNode{}
+	if p.peek().kind == tokenRightBracket {
+		p.next()
+		return list, nil
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		list.items = append(list.items, item)
+
+		t := p.next()
+		if t.kind == tokenRightBracket {
+			return list, nil
+		}
+		if t.kind != tokenComma {
+			return nil, unexpectedToken(t, "',' or ']'")
+		}
+	}
+}
+
+// CompileExpression parses a filter expression written in the bcftools dialect
+func CompileExpression(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, unexpectedToken(t, "an operator")
+	}
+
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Clauses splits the expression by the operators of the lowest precedence outside of parentheses,
+// "A && B || C" gives "A && B" and "C". An expression without such operators is a single clause
+func (e *Expression) Clauses() []*Expression {
+	tokens, err := tokenize(e.source)
+	if err != nil {
+		return []*Expression{e}
+	}
+	runes := []rune(e.source)
+
+	for _, level := range [][]string{{"||", "|"}, {"&&", "&"}} {
+		var sources []string
+		depth, start := 0, 0
+		for _, t := range tokens {
+			switch t.kind {
+			case tokenLeftParen, tokenLeftBracket:
+				depth++
+			case tokenRightParen, tokenRightBracket:
+				depth--
+			case tokenOperator:
+				if depth == 0 && slices.Contains(level, t.value) {
+					sources = append(sources, strings.TrimSpace(string(runes[start:t.pos])))
+					start = t.pos + len([]rune(t.value))
+				}
+			}
+		}
+		if len(sources) == 0 {
+			continue
+		}
+		sources = append(sources, strings.TrimSpace(string(runes[start:])))
+
+		clauses := make([]*Expression, 0, len(sources))
+		for _, source := range sources {
+			clause, err := CompileExpression(source)
+			if err != nil {
+				return []*Expression{e}
+			}
+			clauses = append(clauses, clause)
+		}
+		return clauses
+	}
+	return []*Expression{e}
+}
@@ -0,0 +1,239 @@
+package functions_go
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// Validate checks the expression against the ##INFO and ##FORMAT lines of the header before any record is read:
+// INFO and FORMAT fields must be defined and the types of compared values must match.
+// In lenient mode fields without a definition are logged as warnings and their values are not type checked
+func (e *Expression) Validate(header *VCFHeader, lenient bool) error {
+	v := &expressionValidator{header: header, lenient: lenient, warned: make(map[string]bool)}
+	return v.validateNode(e.root)
+}
+
+func (v *expressionValidator) validateNode(node exprNode) error {
+	header := v.header
+	switch n := node.(type) {
+	case *fieldNode:
+		return v.validateField(n)
+
+	case *sampleIndexNode:
+		return v.validateField(n.field)
+
+	case *valueIndexNode:
+		return v.validateField(n.field)
+
+	case *comparisonNode:
+		if err := v.validateChildren(n.left, n.right); err != nil {
+			return err
+		}
+		return checkComparison(n, header)
+
+	case *arithmeticNode:
+		if err := v.validateChildren(n.left, n.right); err != nil {
+			return err
+		}
+		for _, operand := range []exprNode{n.left, n.right} {
+			if operandType := typeOf(operand, header); operandType != typeNumber && operandType != typeAny {
+				return fmt.Errorf("operator %s expects numbers, %s is %s", n.operator, describeNode(operand), typeNames[operandType])
+			}
+		}
+
+	case *logicalNode:
+		if err := v.validateChildren(n.left, n.right); err != nil {
+			return err
+		}
+		for _, operand := range []exprNode{n.left, n.right} {
+			if err := checkCondition(operand, header); err != nil {
+				return err
+			}
+		}
+
+	case *notNode:
+		if err := v.validateNode(n.operand); err != nil {
+			return err
+		}
+		return checkCondition(n.operand, header)
+
+	case *regexNode:
+		if err := v.validateNode(n.left); err != nil {
+			return err
+		}
+		if leftType := typeOf(n.left, header); leftType != typeString && leftType != typeAny {
+			return fmt.Errorf("regular expression can only match strings, %s is %s", describeNode(n.left), typeNames[leftType])
+		}
+
+	case *genotypeNode:
+		return v.validateNode(n.value)
+
+	case *listNode:
+		return v.validateChildren(n.items...)
+
+	case *callNode:
+		if err := v.validateChildren(n.args...); err != nil {
+			return err
+		}
+		if err := n.function.checkArguments(n.name, n.args, header); err != nil {
+			return err
+		}
+		return checkRegexArguments(n)
+	}
+	return nil
+}
+
+// checkRegexArguments compiles the literal patterns of match(), so that an invalid pattern
+// is an expression error and not an evaluation error of every record
+func checkRegexArguments(n *callNode) error {
+	if n.name != "match" {
+		return nil
+	}
+	pattern, ok := n.args[1].(*stringNode)
+	if !ok {
+		return nil
+	}
+	if _, err := compileRegex(pattern.value); err != nil {
+		return fmt.Errorf("%s() argument 2: %v", n.name, err)
+	}
+	return nil
+}
+
+func (v *expressionValidator) validateChildren(nodes ...exprNode) error {
+	for _, node := range nodes {
+		if err := v.validateNode(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateField checks that an INFO or FORMAT field is defined in the header
+func (v *expressionValidator) validateField(field *fieldNode) error {
+	header := v.header
+	if field.scope == fieldSite {
+		return nil
+	}
+	if _, exists := header.field(field); exists {
+		return nil
+	}
+
+	if v.lenient {
+		name := "INFO/" + field.name
+		if field.scope == fieldFormat {
+			name = "FMT/" + field.name
+		}
+		if !v.warned[name] {
+			v.warned[name] = true
+			s := fmt.Sprintf("%s is not defined in the header, its type is not checked\n", name)
+			LoggerWarning(s)
+		}
+		return nil
+	}
+
+	if field.scope == fieldFormat {
+		message := fmt.Sprintf("FORMAT field %s is not defined in the header", field.name)
+		if suggestion := closestName(field.name, slices.Collect(maps.Keys(header.Format))); suggestion != "" {
+			message += fmt.Sprintf(", did you mean FMT/%s?", suggestion)
+		}
+		return fmt.Errorf("%s", message)
+	}
+
+	message := fmt.Sprintf("INFO field %s is not defined in the header", field.name)
+	if _, isFormat := header.Format[field.name]; isFormat {
+		message += fmt.Sprintf(", use FMT/%s for the FORMAT field", field.name)
+	} else if suggestion := closestName(field.name, slices.Collect(maps.Keys(header.Info))); suggestion != "" {
+		message += fmt.Sprintf(", did you mean %s?", suggestion)
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// checkComparison rejects comparisons of values of different types, "." can be compared with any field
+func checkComparison(n *comparisonNode, header *VCFHeader) error {
+	for _, operand := range []exprNode{n.left, n.right} {
+		if literal, ok := operand.(*stringNode); ok && literal.value == "." {
+			return nil
+		}
+	}
+
+	leftType, rightType := typeOf(n.left, header), typeOf(n.right, header)
+	if leftType == typeAny || rightType == typeAny {
+		return nil
+	}
+	if leftType != rightType {
+		return fmt.Errorf("cannot compare %s (%s) with %s (%s)", describeNode(n.left), typeNames[leftType], describeNode(n.right), typeNames[rightType])
+	}
+	if leftType == typeBool && n.operator != "==" && n.operator != "!=" {
+		return fmt.Errorf("operator %s is not supported for booleans", n.operator)
+	}
+	return nil
+}
+
+// checkCondition rejects numbers and strings used as conditions, for example DP && AF>0.1
+func checkCondition(node exprNode, header *VCFHeader) error {
+	nodeType := typeOf(node, header)
+	if nodeType == typeNumber || nodeType == typeString {
+		return fmt.Errorf("%s is %s, a condition is expected", describeNode(node), typeNames[nodeType])
+	}
+	return nil
+}
+
+// describeNode returns a short description of the node for error messages
+func describeNode(node exprNode) string {
+	switch n := node.(type) {
+	case *fieldNode:
+		switch n.scope {
+		case fieldInfo:
+			return "INFO/" + n.name
+		case fieldFormat:
+			return "FMT/" + n.name
+		}
+		return n.name
+	case *sampleIndexNode:
+		return fmt.Sprintf("%s[%d]", describeNode(n.field), n.sample)
+	case *valueIndexNode:
+		return fmt.Sprintf("%s[%d]", describeNode(n.field), n.index)
+	case *stringNode:
+		return strconv.Quote(n.value)
+	case *numberNode:
+		return strconv.FormatFloat(n.value, 'g', -1, 64)
+	case *callNode:
+		return n.name + "()"
+	}
+	return "expression"
+}
+
+// closestName returns the candidate within two edits of the name, or an empty string
+func closestName(name string, candidates []string) string {
+	slices.Sort(candidates)
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if distance := editDistance(name, candidate); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
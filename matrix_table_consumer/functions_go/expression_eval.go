@@ -0,0 +1,828 @@
+package functions_go
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	fieldSite = iota
+	fieldInfo
+	fieldFormat
+)
+
+// siteFields are the fields that do not belong to INFO or FORMAT
+var siteFields = []string{"CHROM", "POS", "ID", "REF", "ALT", "QUAL", "FILTER", "TYPE", "N_ALT", "N_SAMPLES"}
+
+// sampleValues holds one value per sample of a FORMAT field
+type sampleValues []any
+
+// arrayValues holds the values of a multi-valued INFO field (Number=A, R, G or .)
+type arrayValues []any
+
+// unknownValue is the result of a comparison with a missing value when a missing value policy is set.
+// Conditions combine it with three-valued logic, so the policy decides the record only if a missing value
+// decides the result: any(FMT/DP>10) is true if one sample passes even if another sample has DP=.
+type unknownValue struct{}
+
+const (
+	conditionFalse = iota
+	conditionTrue
+	conditionUnknown
+)
+
+type evalContext struct {
+	row *VCFRow
+	// missingField is the first field with a missing value read during the evaluation
+	missingField string
+	// missingPolicy is MissingEvaluate, MissingPass, MissingFail or MissingError
+	missingPolicy string
+}
+
+type exprNode interface {
+	eval(ctx *evalContext) (any, error)
+}
+
+type numberNode struct {
+	value float64
+}
+
+type stringNode struct {
+	value string
+}
+
+type fieldNode struct {
+	name  string
+	scope int
+}
+
+type comparisonNode struct {
+	left     exprNode
+	right    exprNode
+	operator string
+}
+
+type arithmeticNode struct {
+	left     exprNode
+	right    exprNode
+	operator string
+}
+
+type logicalNode struct {
+	left     exprNode
+	right    exprNode
+	operator string
+}
+
+type notNode struct {
+	operand exprNode
+}
+
+type regexNode struct {
+	left   exprNode
+	re     *regexp.Regexp
+	negate bool
+}
+
+type genotypeNode struct {
+	value   exprNode
+	keyword string
+	negate  bool
+}
+
+type sampleIndexNode struct {
+	field  *fieldNode
+	sample int
+}
+
+type valueIndexNode struct {
+	field *fieldNode
+	index int
+}
+
+type listNode struct {
+	items []exprNode
+}
+
+type callNode struct {
+	function FilterFunction
+	name     string
+	args     []exprNode
+}
+
+// newFieldNode resolves INFO/, FMT/ and FORMAT/ prefixes of a field name
+func newFieldNode(name string) *fieldNode {
+	upper := strings.ToUpper(name)
+	switch {
+	case strings.HasPrefix(upper, "INFO/"):
+		return &fieldNode{name: name[len("INFO/"):], scope: fieldInfo}
+	case strings.HasPrefix(upper, "FMT/"):
+		return &fieldNode{name: name[len("FMT/"):], scope: fieldFormat}
+	case strings.HasPrefix(upper, "FORMAT/"):
+		return &fieldNode{name: name[len("FORMAT/"):], scope: fieldFormat}
+	case name == "GT":
+		return &fieldNode{name: name, scope: fieldFormat}
+	case slices.Contains(siteFields, name):
+		return &fieldNode{name: name, scope: fieldSite}
+	}
+	return &fieldNode{name: name, scope: fieldInfo}
+}
+
+// newGenotypeNode creates a node for GT="het" like comparisons, nil if the operands are not GT and a keyword
+func newGenotypeNode(left, right exprNode, negate bool) exprNode {
+	keyword, isString := right.(*stringNode)
+	if !isString {
+		left, right = right, left
+		keyword, isString = right.(*stringNode)
+	}
+	if !isString || !isGenotypeField(left) || !isGenotypeKeyword(keyword.value) {
+		return nil
+	}
+	return &genotypeNode{value: left, keyword: keyword.value, negate: negate}
+}
+
+func isGenotypeField(node exprNode) bool {
+	if index, ok := node.(*sampleIndexNode); ok {
+		node = index.field
+	}
+	field, ok := node.(*fieldNode)
+	return ok && field.scope == fieldFormat && field.name == "GT"
+}
+
+func (n *numberNode) eval(ctx *evalContext) (any, error) {
+	return n.value, nil
+}
+
+func (n *stringNode) eval(ctx *evalContext) (any, error) {
+	return n.value, nil
+}
+
+func (n *fieldNode) eval(ctx *evalContext) (any, error) {
+	value, err := n.value(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx.markMissing(n, value)
+	return value, nil
+}
+
+// value returns the value of the field without marking missing values
+func (n *fieldNode) value(ctx *evalContext) (any, error) {
+	switch n.scope {
+	case fieldFormat:
+		values := make(sampleValues, len(ctx.row.Samples))
+		for i := range ctx.row.Samples {
+			value, err := ctx.row.GetSampleValue(i, n.name)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	case fieldInfo:
+		return ctx.row.GetValue("INFO/" + n.name)
+	}
+	return ctx.row.GetValue(n.name)
+}
+
+func (n *comparisonNode) eval(ctx *evalContext) (any, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return broadcast(left, right, func(a, b any) (any, error) {
+		if isUnknown(a) || isUnknown(b) {
+			return unknownValue{}, nil
+		}
+		// X="." checks whether the value is missing, other comparisons with a missing value are unknown
+		if (a == nil || b == nil) && a != "." && b != "." {
+			return ctx.missingResult(), nil
+		}
+		return compareValues(n.operator, a, b)
+	})
+}
+
+func (n *arithmeticNode) eval(ctx *evalContext) (any, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return broadcast(left, right, func(a, b any) (any, error) {
+		return calculate(n.operator, a, b)
+	})
+}
+
+func (n *logicalNode) eval(ctx *evalContext) (any, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// & and | combine the values of the same sample, && and || combine whole records
+	if n.operator == "&" || n.operator == "|" {
+		right, err := n.right.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return broadcast(left, right, func(a, b any) (any, error) {
+			return combineBooleans(n.operator, a, b)
+		})
+	}
+
+	leftCondition, err := toCondition(left)
+	if err != nil {
+		return nil, err
+	}
+	if n.operator == "&&" && leftCondition == conditionFalse {
+		return false, nil
+	}
+	if n.operator == "||" && leftCondition == conditionTrue {
+		return true, nil
+	}
+
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rightCondition, err := toCondition(right)
+	if err != nil {
+		return nil, err
+	}
+	return combineConditions(n.operator, leftCondition, rightCondition), nil
+}
+
+func (n *notNode) eval(ctx *evalContext) (any, error) {
+	value, err := n.operand.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mapValues(value, func(v any) (any, error) {
+		condition, err := toCondition(v)
+		if err != nil {
+			return nil, err
+		}
+		switch condition {
+		case conditionTrue:
+			return false, nil
+		case conditionFalse:
+			return true, nil
+		}
+		return unknownValue{}, nil
+	})
+}
+
+func (n *regexNode) eval(ctx *evalContext) (any, error) {
+	value, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mapValues(value, func(v any) (any, error) {
+		if v == nil {
+			return ctx.missingResult(), nil
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("regular expression can only match strings, got %s", valueTypeName(v))
+		}
+		return n.re.MatchString(s) != n.negate, nil
+	})
+}
+
+func (n *genotypeNode) eval(ctx *evalContext) (any, error) {
+	value, err := n.value.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mapValues(value, func(v any) (any, error) {
+		if v == nil {
+			return ctx.missingResult(), nil
+		}
+		gt, ok := v.(string)
+		if !ok {
+			return false, nil
+		}
+		return matchGenotype(gt, n.keyword) != n.negate, nil
+	})
+}
+
+func (n *sampleIndexNode) eval(ctx *evalContext) (any, error) {
+	if n.sample >= len(ctx.row.Samples) {
+		return nil, fmt.Errorf("sample index %d is out of range, the record has %d samples", n.sample, len(ctx.row.Samples))
+	}
+	value, err := ctx.row.GetSampleValue(n.sample, n.field.name)
+	if err != nil {
+		return nil, err
+	}
+	ctx.markMissing(n.field, value)
+	return value, nil
+}
+
+func (n *valueIndexNode) eval(ctx *evalContext) (any, error) {
+	value, err := n.field.value(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var item any
+	if values, ok := value.(arrayValues); ok {
+		if n.index < len(values) {
+			item = values[n.index]
+		}
+	} else if n.index == 0 {
+		item = value
+	}
+	ctx.markMissing(n.field, item)
+	return item, nil
+}
+
+// markMissing remembers the field if its value or any of its sample or array values is missing
+func (ctx *evalContext) markMissing(field *fieldNode, value any) {
+	if ctx.missingField == "" && containsMissing(value) {
+		ctx.missingField = describeNode(field)
+	}
+}
+
+// missingResult is the result of a comparison with a missing value: false without a missing value policy,
+// otherwise unknown
+func (ctx *evalContext) missingResult() any {
+	if ctx.missingPolicy == MissingEvaluate {
+		return false
+	}
+	return unknownValue{}
+}
+
+// resolveMissing replaces unknown results of a condition with the result of the missing value policy
+func (ctx *evalContext) resolveMissing(value any) (any, error) {
+	return mapValues(value, func(v any) (any, error) {
+		if !isUnknown(v) {
+			return v, nil
+		}
+		switch ctx.missingPolicy {
+		case MissingPass:
+			return true, nil
+		case MissingError:
+			return nil, fmt.Errorf("missing value of %s", ctx.missingName())
+		}
+		return false, nil
+	})
+}
+
+// missingName returns the field whose missing value made the result unknown
+func (ctx *evalContext) missingName() string {
+	if ctx.missingField == "" {
+		return "expression"
+	}
+	return ctx.missingField
+}
+
+func isUnknown(value any) bool {
+	_, ok := value.(unknownValue)
+	return ok
+}
+
+func containsMissing(value any) bool {
+	if value == nil {
+		return true
+	}
+	items, isVector := vectorItems(value)
+	return isVector && slices.ContainsFunc(items, containsMissing)
+}
+
+func (n *listNode) eval(ctx *evalContext) (any, error) {
+	items := make([]any, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = value
+	}
+	return items, nil
+}
+
+func (n *callNode) eval(ctx *evalContext) (any, error) {
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		if n.function.params[i] == typeField {
+			args[i] = arg
+			continue
+		}
+
+		value, err := arg.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// count() and F_PASS() return numbers and can not be unknown, the policy is applied to each sample
+		if n.function.params[i] == typeCondition && n.function.returns == typeNumber {
+			value, err = ctx.resolveMissing(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		args[i] = value
+	}
+	return n.spread(ctx, args)
+}
+
+// spread calls the function for each sample or INFO value passed instead of a single value
+func (n *callNode) spread(ctx *evalContext, args []any) (any, error) {
+	for i, arg := range args {
+		if n.function.params[i] == typeField || isCollectionType(n.function.params[i]) {
+			continue
+		}
+
+		items, isVector := vectorItems(arg)
+		if !isVector {
+			continue
+		}
+
+		result := make([]any, len(items))
+		for item := range items {
+			itemArgs := slices.Clone(args)
+			for j := i; j < len(args); j++ {
+				if isCollectionType(n.function.params[j]) || !sameVectorKind(arg, args[j]) {
+					continue
+				}
+				values, _ := vectorItems(args[j])
+				itemArgs[j] = nil
+				if item < len(values) {
+					itemArgs[j] = values[item]
+				}
+			}
+
+			value, err := n.spread(ctx, itemArgs)
+			if err != nil {
+				return nil, err
+			}
+			result[item] = value
+		}
+		return newVector(arg, result), nil
+	}
+	return n.call(ctx, args)
+}
+
+func (n *callNode) call(ctx *evalContext, args []any) (any, error) {
+	result, err := n.function.call(ctx.row, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %v", n.name, err)
+	}
+	return result, nil
+}
+
+// broadcast applies a binary function to scalars, to each sample or to each value of INFO arrays.
+// Samples are the outer dimension, so a FORMAT field compared with an INFO array gives an array per sample
+func broadcast(left, right any, function func(a, b any) (any, error)) (any, error) {
+	outer := left
+	if _, ok := right.(sampleValues); ok {
+		outer = right
+	} else if _, ok := left.(sampleValues); !ok {
+		if _, ok := right.(arrayValues); ok {
+			outer = right
+		}
+	}
+
+	items, isVector := vectorItems(outer)
+	if !isVector {
+		return function(left, right)
+	}
+
+	leftItems, leftIsVector := vectorItems(left)
+	leftIsVector = leftIsVector && sameVectorKind(outer, left)
+	rightItems, rightIsVector := vectorItems(right)
+	rightIsVector = rightIsVector && sameVectorKind(outer, right)
+	if leftIsVector && rightIsVector && len(leftItems) != len(rightItems) {
+		return nil, fmt.Errorf("cannot compare arrays of different length (%d and %d)", len(leftItems), len(rightItems))
+	}
+
+	result := make([]any, len(items))
+	for i := range items {
+		a, b := left, right
+		if leftIsVector {
+			a = leftItems[i]
+		}
+		if rightIsVector {
+			b = rightItems[i]
+		}
+		value, err := broadcast(a, b, function)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = value
+	}
+	return newVector(outer, result), nil
+}
+
+// mapValues applies a function to a scalar, to each sample or to each value of an INFO array
+func mapValues(value any, function func(v any) (any, error)) (any, error) {
+	items, isVector := vectorItems(value)
+	if !isVector {
+		return function(value)
+	}
+
+	result := make([]any, len(items))
+	for i, v := range items {
+		mapped, err := mapValues(v, function)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = mapped
+	}
+	return newVector(value, result), nil
+}
+
+// vectorItems returns the items of per sample values or of an INFO array
+func vectorItems(value any) ([]any, bool) {
+	switch v := value.(type) {
+	case sampleValues:
+		return v, true
+	case arrayValues:
+		return v, true
+	}
+	return nil, false
+}
+
+func sameVectorKind(a, b any) bool {
+	_, aIsSamples := a.(sampleValues)
+	_, bIsSamples := b.(sampleValues)
+	_, aIsArray := a.(arrayValues)
+	_, bIsArray := b.(arrayValues)
+	return (aIsSamples && bIsSamples) || (aIsArray && bIsArray)
+}
+
+// newVector creates a vector of the same kind as the template
+func newVector(template any, items []any) any {
+	if _, ok := template.(sampleValues); ok {
+		return sampleValues(items)
+	}
+	return arrayValues(items)
+}
+
+// valueTypeName returns the type of a value for error messages, values are not included
+// so that the errors of different rows can be grouped
+func valueTypeName(value any) string {
+	if _, ok := toNumber(value); ok {
+		return "number"
+	}
+	switch value.(type) {
+	case nil:
+		return "missing value"
+	case unknownValue:
+		return "unknown condition"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case sampleValues:
+		return "sample values"
+	case arrayValues, []any:
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func toNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// toBool converts a value to boolean, per sample values and INFO arrays are true if any item is true,
+// unknown values are false
+func toBool(value any) (bool, error) {
+	condition, err := toCondition(value)
+	return condition == conditionTrue, err
+}
+
+// toCondition converts a value to true, false or unknown. Per sample values and INFO arrays are true
+// if any item is true, otherwise unknown if any item is unknown
+func toCondition(value any) (int, error) {
+	switch v := value.(type) {
+	case nil:
+		return conditionFalse, nil
+	case bool:
+		if v {
+			return conditionTrue, nil
+		}
+		return conditionFalse, nil
+	case unknownValue:
+		return conditionUnknown, nil
+	case sampleValues, arrayValues:
+		items, _ := vectorItems(v)
+		result := conditionFalse
+		for _, item := range items {
+			condition, err := toCondition(item)
+			if err != nil {
+				return conditionFalse, err
+			}
+			if condition == conditionTrue {
+				return conditionTrue, nil
+			}
+			if condition == conditionUnknown {
+				result = conditionUnknown
+			}
+		}
+		return result, nil
+	}
+	return conditionFalse, fmt.Errorf("expected boolean, got %s", valueTypeName(value))
+}
+
+// conditionResult converts true, false or unknown to the value of a condition
+func conditionResult(condition int) any {
+	if condition == conditionUnknown {
+		return unknownValue{}
+	}
+	return condition == conditionTrue
+}
+
+func compareValues(operator string, left, right any) (any, error) {
+	// X="." and X!="." check whether the value is missing
+	if operator == "==" || operator == "!=" {
+		if right == "." && left != "." {
+			return (left == nil) == (operator == "=="), nil
+		}
+		if left == "." && right != "." {
+			return (right == nil) == (operator == "=="), nil
+		}
+	}
+
+	if left == nil || right == nil {
+		return false, nil
+	}
+
+	if a, ok := toNumber(left); ok {
+		b, ok := toNumber(right)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare number with %s", valueTypeName(right))
+		}
+		switch operator {
+		case "==":
+			return a == b, nil
+		case "!=":
+			return a != b, nil
+		case "<":
+			return a < b, nil
+		case "<=":
+			return a <= b, nil
+		case ">":
+			return a > b, nil
+		case ">=":
+			return a >= b, nil
+		}
+	}
+
+	if a, ok := left.(string); ok {
+		b, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare string with %s", valueTypeName(right))
+		}
+		switch operator {
+		case "==":
+			return a == b, nil
+		case "!=":
+			return a != b, nil
+		case "<":
+			return a < b, nil
+		case "<=":
+			return a <= b, nil
+		case ">":
+			return a > b, nil
+		case ">=":
+			return a >= b, nil
+		}
+	}
+
+	if a, ok := left.(bool); ok {
+		b, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare boolean with %s", valueTypeName(right))
+		}
+		switch operator {
+		case "==":
+			return a == b, nil
+		case "!=":
+			return a != b, nil
+		}
+	}
+
+	return nil, fmt.Errorf("operator %s is not supported for %s and %s", operator, valueTypeName(left), valueTypeName(right))
+}
+
+func calculate(operator string, left, right any) (any, error) {
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	a, okLeft := toNumber(left)
+	b, okRight := toNumber(right)
+	if !okLeft || !okRight {
+		return nil, fmt.Errorf("operator %s expects numbers, got %s and %s", operator, valueTypeName(left), valueTypeName(right))
+	}
+
+	switch operator {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, nil
+		}
+		return a / b, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", operator)
+}
+
+func combineBooleans(operator string, left, right any) (any, error) {
+	a, err := toCondition(left)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toCondition(right)
+	if err != nil {
+		return nil, err
+	}
+	return combineConditions(operator, a, b), nil
+}
+
+// combineConditions applies && (&) or || (|) with three-valued logic: false && unknown is false,
+// true || unknown is true, other combinations with unknown are unknown
+func combineConditions(operator string, a, b int) any {
+	if operator == "&&" || operator == "&" {
+		if a == conditionFalse || b == conditionFalse {
+			return false
+		}
+		if a == conditionUnknown || b == conditionUnknown {
+			return unknownValue{}
+		}
+		return true
+	}
+	if a == conditionTrue || b == conditionTrue {
+		return true
+	}
+	if a == conditionUnknown || b == conditionUnknown {
+		return unknownValue{}
+	}
+	return false
+}
+
+// variantType returns the bcftools variant types (snp, mnp, indel, bnd, other, ref) of the record
+func variantType(ref, alt string) string {
+	types := make([]string, 0, 1)
+	for allele := range strings.SplitSeq(alt, ",") {
+		var variant string
+		switch {
+		case allele == "." || allele == "*" || allele == "<*>" || allele == "<NON_REF>":
+			variant = "ref"
+		case strings.ContainsAny(allele, "[]"):
+			variant = "bnd"
+		case strings.HasPrefix(allele, "<"):
+			variant = "other"
+		case len(allele) != len(ref):
+			variant = "indel"
+		default:
+			// Trim the bases shared by REF and ALT
+			start, end := 0, len(ref)
+			for start < end && ref[start] == allele[start] {
+				start++
+			}
+			for end > start && ref[end-1] == allele[end-1] {
+				end--
+			}
+			switch end - start {
+			case 0:
+				variant = "ref"
+			case 1:
+				variant = "snp"
+			default:
+				variant = "mnp"
+			}
+		}
+		if !slices.Contains(types, variant) {
+			types = append(types, variant)
+		}
+	}
+	slices.Sort(types)
+	return strings.Join(types, ",")
+}
@@ -2,15 +2,25 @@ package functions_go
 
 import (
 	"bufio"
-	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"os"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
+)
 
-	"github.com/PHILIPP111007/govaluate"
+// Политики для записей, в которых выражение прочитало отсутствующее значение (поле не задано или ".")
+const (
+	// MissingEvaluate: сравнения с отсутствующими значениями ложны, запись оценивается как обычно
+	MissingEvaluate = ""
+	// MissingPass: запись проходит фильтр
+	MissingPass = "pass"
+	// MissingFail: запись не проходит фильтр
+	MissingFail = "fail"
+	// MissingError: запись считается ошибкой вычисления и попадает в отчет
+	MissingError = "error"
 )
 
 // ParseVCFRow парсит строку VCF
@@ -39,6 +49,7 @@ func ParseVCFRow(line string) *VCFRow {
 		InfoFields: make(map[string]string),
 		Pos:        pos32,
 		Qual:       qual8,
+		qual:       parts[5],
 	}
 
 	if len(parts) > 8 {
@@ -48,30 +59,14 @@ func ParseVCFRow(line string) *VCFRow {
 		row.Samples = parts[9:]
 	}
 
-	// Парсим INFO поле
-	infoParts := strings.SplitSeq(row.Info, ";")
-	for part := range infoParts {
-		if strings.Contains(part, "=") {
-			kv := strings.SplitN(part, "=", 2)
-
-			parts := strings.Split(kv[1], ",")
-			var num_parts []float64
-
-			for _, part := range parts {
-				if num, err := strconv.ParseFloat(part, 64); err == nil {
-					num_parts = append(num_parts, num)
-				}
-			}
-			if len(num_parts) > 0 {
-				result_num := slices.Max(num_parts)
-				result_num_str := fmt.Sprintf("%f", result_num)
-
-				if len(kv) == 2 {
-					row.InfoFields[kv[0]] = result_num_str
-				}
+	// Парсим INFO поле, значения хранятся как есть, флаги хранятся с пустым значением
+	if row.Info != "." {
+		for part := range strings.SplitSeq(row.Info, ";") {
+			if part == "" {
+				continue
 			}
-		} else {
-			row.InfoFields[part] = "true"
+			key, value, _ := strings.Cut(part, "=")
+			row.InfoFields[key] = value
 		}
 	}
 
@@ -80,9 +75,20 @@ func ParseVCFRow(line string) *VCFRow {
 
 // GetValue возвращает значение поля по имени
 func (r *VCFRow) GetValue(fieldName string) (any, error) {
+	if infoName, isInfo := strings.CutPrefix(fieldName, "INFO/"); isInfo {
+		return r.getInfoValue(infoName)
+	}
+
 	switch fieldName {
 	case "QUAL":
-		return r.Qual, nil
+		// QUAL может быть дробным и больше 127, поэтому берется исходное значение
+		if r.qual == "." || r.qual == "" {
+			return nil, nil
+		}
+		if qual, err := strconv.ParseFloat(r.qual, 64); err == nil {
+			return qual, nil
+		}
+		return nil, fmt.Errorf("invalid QUAL value '%s'", r.qual)
 	case "CHROM":
 		return r.Chrom, nil
 	case "POS":
@@ -95,210 +101,733 @@ func (r *VCFRow) GetValue(fieldName string) (any, error) {
 		return r.Alt, nil
 	case "FILTER":
 		return r.Filter, nil
+	case "TYPE":
+		return variantType(r.Ref, r.Alt), nil
+	case "N_ALT":
+		if r.Alt == "." {
+			return 0, nil
+		}
+		return strings.Count(r.Alt, ",") + 1, nil
+	case "N_SAMPLES":
+		return len(r.Samples), nil
 	default:
-		// INFO поля
-		if value, exists := r.InfoFields[fieldName]; exists {
-			if value == "true" {
-				return true, nil
-			}
-			// Пробуем парсить как число
+		return r.getInfoValue(fieldName)
+	}
+}
 
-			valueParts := strings.Split(value, ",")
-			value = valueParts[0]
+// getInfoValue возвращает значение INFO поля с учетом Number и Type из заголовка.
+// Поля со списком значений (Number=A, R, G, .) возвращаются как arrayValues, отсутствующее поле - nil
+func (r *VCFRow) getInfoValue(fieldName string) (any, error) {
+	value, exists := r.InfoFields[fieldName]
+	if !exists {
+		return nil, nil
+	}
 
-			if num, err := strconv.ParseFloat(value, 64); err == nil {
-				return num, nil
-			}
-			return value, nil
-		}
-		return nil, fmt.Errorf("field %s not found", fieldName)
+	field, known := r.header.info(fieldName)
+	if value == "" || (known && field.Type == "Flag") {
+		return true, nil
+	}
+	return parseListValue(value, field, known), nil
+}
+
+// parseListValue парсит значение INFO или FORMAT поля, списки (Number=A, R, G, .) возвращаются как arrayValues.
+// Без заголовка списком считается значение с запятыми
+func parseListValue(value string, field HeaderField, known bool) any {
+	if known && !field.IsList() {
+		return parseTypedValue(value, field, known)
+	}
+
+	items := strings.Split(value, ",")
+	if !known && len(items) == 1 {
+		return parseTypedValue(value, field, known)
+	}
+	values := make(arrayValues, len(items))
+	for i, item := range items {
+		values[i] = parseTypedValue(item, field, known)
+	}
+	return values
+}
+
+// parseTypedValue парсит одно значение INFO или FORMAT поля, без заголовка числа определяются по значению
+func parseTypedValue(value string, field HeaderField, known bool) any {
+	if value == "." {
+		return nil
+	}
+	if known && !field.IsNumeric() {
+		return value
 	}
+	if num, err := strconv.ParseFloat(value, 64); err == nil {
+		return num
+	}
+	return value
 }
 
-// FilterFunctions содержит функции для фильтрации
-var FilterFunctions = map[string]govaluate.ExpressionFunction{
-	"has": func(args ...any) (any, error) {
-		if len(args) != 2 {
-			return nil, fmt.Errorf("has() expects 2 arguments")
+// GetSampleValue возвращает значение FORMAT поля образца с учетом Number и Type из заголовка, nil если значение отсутствует.
+// Поля со списком значений (AD, PL) возвращаются как arrayValues
+func (r *VCFRow) GetSampleValue(sample int, fieldName string) (any, error) {
+	if sample < 0 || sample >= len(r.Samples) {
+		return nil, fmt.Errorf("sample %d not found", sample)
+	}
+
+	if r.formatIndex == nil {
+		r.formatIndex = make(map[string]int)
+		for i, key := range strings.Split(r.Format, ":") {
+			r.formatIndex[key] = i
 		}
-		_, ok := args[0].(string)
-		if !ok {
-			return nil, fmt.Errorf("first argument must be string")
+	}
+
+	index, exists := r.formatIndex[fieldName]
+	if !exists {
+		return nil, nil
+	}
+
+	var value string
+	for i, part := range strings.Split(r.Samples[sample], ":") {
+		if i == index {
+			value = part
+			break
 		}
-		_, ok = args[1].(string)
-		if !ok {
-			return nil, fmt.Errorf("second argument must be string")
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	if fieldName == "GT" {
+		return value, nil
+	}
+	field, known := r.header.format(fieldName)
+	return parseListValue(value, field, known), nil
+}
+
+// hasField проверяет, задано ли поле в строке
+func (r *VCFRow) hasField(field *fieldNode) bool {
+	switch field.scope {
+	case fieldFormat:
+		if r.Format == "" {
+			return false
 		}
+		return slices.Contains(strings.Split(r.Format, ":"), field.name)
+	case fieldInfo:
+		_, exists := r.InfoFields[field.name]
+		return exists
+	}
+	value, err := r.GetValue(field.name)
+	return err == nil && value != "."
+}
 
-		// Для простоты всегда возвращаем true, реальная реализация будет в EvaluateRow
-		return true, nil
-	},
+// EvaluateRow оценивает строку VCF по выражению, сравнения с отсутствующими значениями ложны
+func EvaluateRow(row *VCFRow, expression *Expression) (bool, error) {
+	matches, _, err := evaluateRow(row, expression, MissingEvaluate)
+	return matches, err
 }
 
-// EvaluateRow оценивает строку VCF по выражению
-func EvaluateRow(row *VCFRow, expression *govaluate.EvaluableExpression) (bool, error) {
-	parameters := make(map[string]any)
+// evaluateRow также возвращает поле с отсутствующим значением, если от него зависит результат.
+// С политикой отсутствующих значений сравнения с ними неизвестны, и политика решает судьбу записи,
+// только если результат всего выражения неизвестен
+func evaluateRow(row *VCFRow, expression *Expression, missingPolicy string) (bool, string, error) {
+	ctx := &evalContext{row: row, missingPolicy: missingPolicy}
 
-	// Добавляем все поля VCF как параметры
-	if qual, err := row.GetValue("QUAL"); err == nil {
-		parameters["QUAL"] = qual
+	result, err := expression.root.eval(ctx)
+	if err != nil {
+		return false, "", err
 	}
-	parameters["CHROM"] = row.Chrom
-	parameters["POS"] = row.Pos
-	parameters["ID"] = row.ID
-	parameters["REF"] = row.Ref
-	parameters["ALT"] = row.Alt
-	parameters["FILTER"] = row.Filter
 
-	// Добавляем INFO поля
-	for key, value := range row.InfoFields {
-		if num, err := strconv.ParseFloat(value, 64); err == nil {
-			parameters[key] = num
-		} else if value == "true" {
-			parameters[key] = true
-		} else if value == "false" {
-			parameters[key] = false
-		} else {
-			parameters[key] = value
+	switch result.(type) {
+	case nil, bool, unknownValue, sampleValues, arrayValues:
+		condition, err := toCondition(result)
+		if condition == conditionUnknown {
+			return false, ctx.missingName(), err
 		}
+		return condition == conditionTrue, "", err
+	}
+
+	return false, "", fmt.Errorf("expression did not return boolean")
+}
+
+// rowFilter решает, какие строки проходят фильтр
+type rowFilter struct {
+	expression    *Expression
+	clauses       []*Expression
+	header        *VCFHeader
+	softFilter    string
+	missingPolicy string
+	lenientHeader bool
+	exclude       bool
+	// name и outputVCF - имя выхода FilterMulti и путь выходного файла
+	name      string
+	outputVCF string
+	// samples выбирает колонки образцов, nil - образцы не меняются
+	samples *sampleSubset
+}
+
+// newRowFilter компилирует выражение include или exclude.
+// Без выражения проходят все строки, если выбраны образцы или sites only
+func newRowFilter(options FilterOptions) (*rowFilter, error) {
+	if options.Include != "" && options.Exclude != "" {
+		return nil, fmt.Errorf("include and exclude expressions can not be used together")
 	}
 
-	result, err := expression.Evaluate(parameters)
+	samples, err := newSampleSubset(options)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	if boolResult, ok := result.(bool); ok {
-		return boolResult, nil
+	source := options.Include
+	if options.Exclude != "" {
+		source = options.Exclude
+	}
+	if source == "" && samples == nil {
+		return nil, fmt.Errorf("include or exclude expression is required")
 	}
 
-	return false, fmt.Errorf("expression did not return boolean")
+	switch options.MissingPolicy {
+	case MissingEvaluate, MissingPass, MissingFail, MissingError:
+	default:
+		return nil, fmt.Errorf("unknown missing value policy '%s', expected pass, fail or error", options.MissingPolicy)
+	}
+
+	if options.SoftFilter != "" {
+		if source == "" {
+			return nil, fmt.Errorf("soft filter requires an include or exclude expression")
+		}
+		if options.SoftFilter == "PASS" || options.SoftFilter == "." || strings.ContainsAny(options.SoftFilter, "; \t,=\"<>") {
+			return nil, fmt.Errorf("invalid soft filter name '%s'", options.SoftFilter)
+		}
+	}
+
+	filter := &rowFilter{
+		header:        NewVCFHeader(),
+		softFilter:    options.SoftFilter,
+		missingPolicy: options.MissingPolicy,
+		lenientHeader: options.LenientHeader,
+		exclude:       options.Exclude != "",
+		name:          options.Name,
+		outputVCF:     options.OutputVCF,
+		samples:       samples,
+	}
+	if source != "" {
+		filter.expression, err = CompileExpression(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse expression '%s': %v", source, err)
+		}
+		filter.clauses = filter.expression.Clauses()
+	}
+	return filter, nil
+}
+
+// headerLine возвращает строку ##FILTER для soft filter
+func (f *rowFilter) headerLine() string {
+	description := "Set if not true: "
+	if f.exclude {
+		description = "Set if true: "
+	}
+	description += f.expression.String()
+	description = strings.ReplaceAll(description, "\\", "\\\\")
+	description = strings.ReplaceAll(description, "\"", "\\\"")
+	return fmt.Sprintf("##FILTER=<ID=%s,Description=\"%s\">", f.softFilter, description)
+}
+
+// addFilter добавляет имя фильтра в колонку FILTER строки
+func addFilter(line string, name string) string {
+	parts := strings.SplitN(line, "\t", 8)
+	if len(parts) < 8 {
+		return line
+	}
+
+	switch parts[6] {
+	case "PASS", ".", "":
+		parts[6] = name
+	default:
+		if !slices.Contains(strings.Split(parts[6], ";"), name) {
+			parts[6] += ";" + name
+		}
+	}
+	return strings.Join(parts, "\t")
+}
+
+// maxErrorLines ограничивает число номеров строк для каждой ошибки в отчете
+const maxErrorLines = 5
+
+func newFilterStats(clauses int) *filterStats {
+	return &filterStats{
+		errors:  make(map[string]*FilterErrorReport),
+		clauses: make([]int64, clauses),
+	}
+}
+
+// addError учитывает ошибку вычисления строки
+func (s *filterStats) addError(message string, line int64) {
+	report, exists := s.errors[message]
+	if !exists {
+		report = &FilterErrorReport{Message: message}
+		s.errors[message] = report
+	}
+	report.Count++
+	if len(report.Lines) < maxErrorLines {
+		report.Lines = append(report.Lines, line)
+	}
 }
 
-// ParallelFilterRows параллельно фильтрует строки
-func ParallelFilterRows(lines <-chan string, wg *sync.WaitGroup, output chan<- string, expression *govaluate.EvaluableExpression) {
+// countClauses считает строки, для которых верна каждая часть выражения верхнего уровня
+func (s *filterStats) countClauses(row *VCFRow, filter *rowFilter, matches bool) {
+	if len(filter.clauses) == 1 {
+		if matches {
+			s.clauses[0]++
+		}
+		return
+	}
+	for i, clause := range filter.clauses {
+		if passed, err := EvaluateRow(row, clause); err == nil && passed {
+			s.clauses[i]++
+		}
+	}
+}
+
+// filterLine вычисляет выражение для строки и возвращает текст для записи в выходной файл.
+// parsed - строка, разобранная один раз для всех выражений без выбора образцов
+func (f *rowFilter) filterLine(line vcfLine, parsed *VCFRow, stats *filterStats) (string, bool) {
+	// Выражение вычисляется по выбранным образцам и пересчитанным AC, AN и AF
+	text := line.text
+	row := parsed
+	if f.samples != nil {
+		text = f.samples.selectRecord(text)
+		row = ParseVCFRow(text)
+	}
+	if row == nil {
+		return "", false
+	}
+	row.header = f.header
+	stats.input++
+
+	if f.expression == nil {
+		stats.passed++
+		return f.samples.output(text), true
+	}
+
+	matches, missingField, err := evaluateRow(row, f.expression, f.missingPolicy)
+	if err == nil && missingField != "" && f.missingPolicy == MissingError {
+		err = fmt.Errorf("missing value of %s", missingField)
+	}
+	if err != nil {
+		s := fmt.Sprintf("Error evaluating row %d: %v\n", line.number, err)
+		LoggerDebug(s)
+		stats.addError(err.Error(), line.number)
+	}
+	stats.countClauses(row, f, matches)
+
+	passes := err == nil && matches != f.exclude
+	if err == nil && missingField != "" {
+		switch f.missingPolicy {
+		case MissingPass:
+			passes = true
+		case MissingFail:
+			passes = false
+		}
+	}
+	if f.samples != nil {
+		text = f.samples.output(text)
+	}
+	if passes {
+		stats.passed++
+		return text, true
+	}
+	if f.softFilter != "" {
+		return addFilter(text, f.softFilter), true
+	}
+	return "", false
+}
+
+// ParallelFilterRows параллельно фильтрует строки, каждая строка проверяется всеми выражениями
+// и отправляется в выходные файлы, для которых она проходит фильтр
+func ParallelFilterRows(lines <-chan vcfLine, wg *sync.WaitGroup, output chan<- filterResult, filters []*rowFilter, stats []*filterStats) {
 	defer wg.Done()
 
 	for line := range lines {
-		row := ParseVCFRow(line)
-		if row == nil {
-			continue
+		parsed := ParseVCFRow(line.text)
+		for i, filter := range filters {
+			if text, write := filter.filterLine(line, parsed, stats[i]); write {
+				output <- filterResult{output: i, text: text}
+			}
 		}
+	}
+}
 
-		matches, err := EvaluateRow(row, expression)
-		if err != nil {
-			s := fmt.Sprintf("Error evaluating row: %v\n", err)
-			LoggerError(s)
-			continue
+// newFilterReport объединяет статистику worker'ов
+func newFilterReport(filter *rowFilter, stats []*filterStats) *FilterReport {
+	report := &FilterReport{
+		Errors:  make([]FilterErrorReport, 0),
+		Clauses: make([]ClauseReport, len(filter.clauses)),
+	}
+	for i, clause := range filter.clauses {
+		report.Clauses[i].Clause = clause.String()
+	}
+
+	errors := make(map[string]*FilterErrorReport)
+	for _, workerStats := range stats {
+		report.InputRows += workerStats.input
+		report.PassedRows += workerStats.passed
+		for i, passed := range workerStats.clauses {
+			report.Clauses[i].Passed += passed
+		}
+		for message, workerErrors := range workerStats.errors {
+			errorReport, exists := errors[message]
+			if !exists {
+				errorReport = &FilterErrorReport{Message: message}
+				errors[message] = errorReport
+			}
+			errorReport.Count += workerErrors.Count
+			errorReport.Lines = append(errorReport.Lines, workerErrors.Lines...)
 		}
+	}
+	report.FailedRows = report.InputRows - report.PassedRows
 
-		if matches {
-			output <- line
+	for _, errorReport := range errors {
+		slices.Sort(errorReport.Lines)
+		errorReport.Lines = errorReport.Lines[:min(len(errorReport.Lines), maxErrorLines)]
+		report.ErrorRows += errorReport.Count
+		report.Errors = append(report.Errors, *errorReport)
+	}
+	slices.SortFunc(report.Errors, func(a, b FilterErrorReport) int {
+		if a.Count != b.Count {
+			return compareInt64(b.Count, a.Count)
 		}
+		return strings.Compare(a.Message, b.Message)
+	})
+
+	return report
+}
+
+// filterReportJSON возвращает отчет в формате JSON
+func filterReportJSON(report *FilterReport) string {
+	normalizeFilterReport(report)
+	return reportJSON(report)
+}
+
+// multiFilterReportJSON возвращает отчет FilterMulti в формате JSON
+func multiFilterReportJSON(report *MultiFilterReport) string {
+	if report.Outputs == nil {
+		report.Outputs = make([]FilterReport, 0)
 	}
+	for i := range report.Outputs {
+		normalizeFilterReport(&report.Outputs[i])
+	}
+	return reportJSON(report)
 }
 
-func Filter(include string, input_vcf_path string, output_vcf_path string, num_cpu int) {
-	if num_cpu <= 0 {
-		num_cpu = 1
+// normalizeFilterReport заменяет nil на пустые списки, чтобы в JSON были [] вместо null
+func normalizeFilterReport(report *FilterReport) {
+	if report.Errors == nil {
+		report.Errors = make([]FilterErrorReport, 0)
+	}
+	if report.Clauses == nil {
+		report.Clauses = make([]ClauseReport, 0)
 	}
+}
 
-	// Создаем выражение с помощью govaluate
-	expression, err := govaluate.NewEvaluableExpressionWithFunctions(include, FilterFunctions)
-	if err != nil {
-		s := fmt.Sprintf("Failed to parse expression '%s': %v\n", include, err)
+func reportJSON(report any) string {
+	var buffer strings.Builder
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		s := fmt.Sprintf("JSON conversion error: %v\n", err)
 		LoggerError(s)
-		return
+		return "{}"
 	}
+	return strings.TrimSpace(buffer.String())
+}
 
-	var reader *bufio.Reader
-	if strings.HasSuffix(input_vcf_path, ".gz") {
-		f, err := os.Open(input_vcf_path)
-		if err != nil {
-			s := fmt.Sprintf("Failed to open the file: %v\n", err)
-			LoggerError(s)
-			return
+// filterError добавляет имя выхода FilterMulti к ошибке
+func (f *rowFilter) filterError(err error) error {
+	if f.name == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %v", f.name, err)
+}
+
+// writeHeader записывает строку заголовка в выходной файл
+func (o *filterOutput) writeHeader(line string) {
+	filter := o.filter
+	if filter.samples != nil && strings.HasPrefix(line, "#CHROM") {
+		line = filter.samples.selectHeader(line)
+		filter.header.ParseLine(line)
+		line = filter.samples.output(line)
+	} else {
+		filter.header.ParseLine(line)
+	}
+
+	if filter.softFilter != "" {
+		if strings.HasPrefix(line, "##FILTER=<ID="+filter.softFilter+",") {
+			o.filterHeaderFound = true
+		} else if strings.HasPrefix(line, "#CHROM") && !o.filterHeaderFound {
+			fmt.Fprintln(o.writer, filter.headerLine())
 		}
-		defer f.Close()
+	}
+	fmt.Fprintln(o.writer, line)
+}
 
-		gr, err := gzip.NewReader(f)
-		if err != nil {
-			s := fmt.Sprintf("Error creating gzip reader: %v\n", err)
-			LoggerError(s)
-			return
+// validate проверяет образцы и выражение по заголовку до чтения первой записи
+func (o *filterOutput) validate(line string) (string, error) {
+	filter := o.filter
+	if filter.samples != nil {
+		if err := filter.samples.resolve(line); err != nil {
+			return "Invalid samples", filter.filterError(err)
+		}
+		for _, headerLine := range filter.samples.headerLines(filter.header) {
+			filter.header.ParseLine(headerLine)
+			fmt.Fprintln(o.writer, headerLine)
+		}
+	}
+	if filter.expression != nil {
+		if err := filter.expression.Validate(filter.header, filter.lenientHeader); err != nil {
+			return "Invalid expression", filter.filterError(err)
 		}
-		defer gr.Close()
+	}
+	return "", nil
+}
 
-		reader = bufio.NewReader(gr)
-	} else {
-		f, err := os.Open(input_vcf_path)
-		if err != nil {
-			s := fmt.Sprintf("Failed to open the file: %v\n", err)
-			LoggerError(s)
-			return
+// closeOutputs закрывает выходные файлы, при ошибке файлы удаляются
+func closeOutputs(outputs []*filterOutput, remove bool) {
+	for _, output := range outputs {
+		if output.file == nil {
+			continue
+		}
+		output.writer.Flush()
+		output.file.Close()
+		if remove {
+			os.Remove(output.path)
 		}
-		defer f.Close()
+	}
+}
 
-		reader = bufio.NewReader(f)
+// filterVCF читает входной файл один раз и записывает строки, прошедшие каждое выражение, в его выходной файл.
+// Возвращает отчеты выходов или ошибку, из-за которой фильтр не был запущен
+func filterVCF(options FilterOptions, filters []*rowFilter) ([]*FilterReport, error) {
+	num_cpu := options.NumCPU
+	if num_cpu <= 0 {
+		num_cpu = 1
 	}
 
-	outputFile, err := os.Create(output_vcf_path)
+	regions, err := LoadRegions(options.Regions, options.RegionsFile)
 	if err != nil {
-		s := fmt.Sprintf("Error creating file: %v\n", err)
+		s := fmt.Sprintf("Error reading regions: %v\n", err)
 		LoggerError(s)
-		return
+		return nil, fmt.Errorf("%s", strings.TrimSpace(s))
 	}
-	defer outputFile.Close()
 
-	scanner := GetScaner(reader)
-	writer := bufio.NewWriter(outputFile)
-	defer writer.Flush()
+	reader, err := OpenVCFRegions(options.InputVCF, regions)
+	if err != nil {
+		s := fmt.Sprintf("%v\n", err)
+		LoggerError(s)
+		return nil, err
+	}
+	defer reader.Close()
+
+	outputs := make([]*filterOutput, len(filters))
+	for i, filter := range filters {
+		outputs[i] = &filterOutput{filter: filter, path: filter.outputVCF}
+		file, err := os.Create(filter.outputVCF)
+		if err != nil {
+			closeOutputs(outputs, true)
+			s := fmt.Sprintf("Error creating file: %v\n", err)
+			LoggerError(s)
+			return nil, fmt.Errorf("%s", strings.TrimSpace(s))
+		}
+		outputs[i].file = file
+		outputs[i].writer = bufio.NewWriter(file)
+	}
 
 	wg := sync.WaitGroup{}
 	wg.Add(num_cpu)
-	linesChan := make(chan string, 100000)
-	resultsChan := make(chan string, 500000)
+	linesChan := make(chan vcfLine, 100000)
+	resultsChan := make(chan filterResult, 100000)
 
-	// Запускаем worker'ов
+	// Запускаем worker'ов, у каждого своя статистика для каждого выражения
+	stats := make([][]*filterStats, num_cpu)
 	for i := 0; i < num_cpu; i++ {
-		go ParallelFilterRows(linesChan, &wg, resultsChan, expression)
+		stats[i] = make([]*filterStats, len(filters))
+		for j, filter := range filters {
+			stats[i][j] = newFilterStats(len(filter.clauses))
+		}
+		go ParallelFilterRows(linesChan, &wg, resultsChan, filters, stats[i])
 	}
 
-	num := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// Заголовки пишем сразу
-		if strings.HasPrefix(line, "#") {
-			fmt.Fprintln(writer, line)
-			continue
+	// Результаты записываются отдельной горутиной одновременно с чтением: каждая строка дает
+	// до одного результата на выходной файл, и worker'ы не должны ждать, пока освободится канал.
+	// Заголовки записываются до отправки первой строки worker'ам
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for result := range resultsChan {
+			fmt.Fprintln(outputs[result.output].writer, result.text)
+		}
+	}()
+
+	progress := NewProgress("filter", reader.Size)
+	var lineNumber int64 = 0
+	validated := false
+	var validationErr error
+	for reader.Scan() {
+		line := reader.Text()
+		lineNumber++
+
+		// Образцы и выражения проверяются по заголовку до чтения первой записи
+		if !validated && (strings.HasPrefix(line, "#CHROM") || !strings.HasPrefix(line, "#")) {
+			validated = true
+			for _, output := range outputs {
+				if prefix, err := output.validate(line); err != nil {
+					validationErr = fmt.Errorf("%s: %v", prefix, err)
+					break
+				}
+			}
+			if validationErr != nil {
+				break
+			}
 		}
 
-		// Периодически сбрасываем результаты
-		if num >= 500000 {
-			num = 0
-			for len(resultsChan) > 0 {
-				row := <-resultsChan
-				fmt.Fprintln(writer, row)
+		// Заголовки пишем сразу, worker'ы читают заголовок только после получения первой строки
+		if strings.HasPrefix(line, "#") {
+			for _, output := range outputs {
+				output.writeHeader(line)
 			}
-			writer.Flush()
+			continue
 		}
 
-		linesChan <- line
-		num++
+		linesChan <- vcfLine{text: line, number: lineNumber}
+		progress.Update(1, reader.BytesRead())
 	}
+	progress.Close()
 
 	close(linesChan)
 	wg.Wait()
 	close(resultsChan)
+	<-written
+
+	if validationErr != nil {
+		s := fmt.Sprintf("%v\n", validationErr)
+		LoggerError(s)
+		closeOutputs(outputs, true)
+		return nil, validationErr
+	}
 
-	if err := scanner.Err(); err != nil {
-		s := fmt.Sprintf("Reading input: %v\n", err)
+	readErr := reader.Err()
+	if readErr != nil {
+		s := fmt.Sprintf("Reading input: %v\n", readErr)
 		LoggerError(s)
 	}
 
-	// Записываем оставшиеся результаты
-	for row := range resultsChan {
-		fmt.Fprintln(writer, row)
+	closeOutputs(outputs, false)
+
+	reports := make([]*FilterReport, len(filters))
+	for i, filter := range filters {
+		filterStats := make([]*filterStats, num_cpu)
+		for worker := range stats {
+			filterStats[worker] = stats[worker][i]
+		}
+		reports[i] = newFilterReport(filter, filterStats)
+		reports[i].Name = filter.name
+
+		if reports[i].ErrorRows > 0 {
+			s := fmt.Sprintf("%d rows could not be evaluated, see errors in the report\n", reports[i].ErrorRows)
+			if filter.name != "" {
+				s = fmt.Sprintf("%s: %s", filter.name, s)
+			}
+			LoggerWarning(s)
+		}
+		if readErr != nil {
+			reports[i].Error = readErr.Error()
+		}
+	}
+	return reports, nil
+}
+
+// Filter записывает строки, прошедшие фильтр, и возвращает отчет FilterReport в формате JSON.
+// Номера строк в отчете считаются от начала файла, при чтении по индексу - от начала прочитанных регионов
+func Filter(options FilterOptions) string {
+	filter, err := newRowFilter(options)
+	if err != nil {
+		s := fmt.Sprintf("%v\n", err)
+		LoggerError(s)
+		return filterReportJSON(&FilterReport{Error: err.Error()})
+	}
+
+	reports, err := filterVCF(options, []*rowFilter{filter})
+	if err != nil {
+		return filterReportJSON(&FilterReport{Error: err.Error()})
+	}
+	return filterReportJSON(reports[0])
+}
+
+// parseFilterOutputs читает выходы FilterMulti из JSON списка объектов с полями FilterOptions
+func parseFilterOutputs(spec string) ([]FilterOptions, error) {
+	var outputs []FilterOptions
+	decoder := json.NewDecoder(strings.NewReader(spec))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&outputs); err != nil {
+		return nil, fmt.Errorf("invalid outputs specification: %v", err)
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("invalid outputs specification: no outputs")
+	}
+	return outputs, nil
+}
+
+// FilterMulti читает входной файл один раз и записывает каждую запись во все выходы, выражения которых
+// она проходит. Регионы, число CPU и политика отсутствующих значений по умолчанию берутся из options.
+// Выходы задаются JSON списком объектов с полями FilterOptions, возвращает отчет MultiFilterReport в формате JSON
+func FilterMulti(options FilterOptions, outputs_json string) string {
+	outputs, err := parseFilterOutputs(outputs_json)
+	if err != nil {
+		s := fmt.Sprintf("%v\n", err)
+		LoggerError(s)
+		return multiFilterReportJSON(&MultiFilterReport{Error: err.Error()})
+	}
+
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+	filters := make([]*rowFilter, len(outputs))
+	for i, output := range outputs {
+		if output.Name == "" {
+			output.Name = fmt.Sprintf("output_%d", i+1)
+		}
+		if output.MissingPolicy == "" {
+			output.MissingPolicy = options.MissingPolicy
+		}
+
+		var err error
+		switch {
+		case names[output.Name]:
+			err = fmt.Errorf("duplicate output name")
+		case output.OutputVCF == "":
+			err = fmt.Errorf("output_vcf is required")
+		case paths[output.OutputVCF] || output.OutputVCF == options.InputVCF:
+			err = fmt.Errorf("output file %s is used more than once", output.OutputVCF)
+		}
+		if err == nil {
+			filters[i], err = newRowFilter(output)
+		}
+		if err != nil {
+			s := fmt.Sprintf("%s: %v\n", output.Name, err)
+			LoggerError(s)
+			return multiFilterReportJSON(&MultiFilterReport{Error: strings.TrimSpace(s)})
+		}
+		names[output.Name] = true
+		paths[output.OutputVCF] = true
+	}
+
+	reports, err := filterVCF(options, filters)
+	if err != nil {
+		return multiFilterReportJSON(&MultiFilterReport{Error: err.Error()})
+	}
+
+	report := &MultiFilterReport{Outputs: make([]FilterReport, len(reports))}
+	for i, outputReport := range reports {
+		outputReport.OutputVCF = filters[i].outputVCF
+		report.Outputs[i] = *outputReport
+		report.Error = outputReport.Error
+	}
+	if len(reports) > 0 {
+		report.InputRows = reports[0].InputRows
 	}
+	return multiFilterReportJSON(report)
 }
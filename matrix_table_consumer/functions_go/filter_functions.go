@@ -0,0 +1,429 @@
+package functions_go
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	typeAny = iota
+	typeNumber
+	typeString
+	typeBool
+	typeArray
+	// typeField accepts only a field name, the function receives the field itself
+	typeField
+	// typeCondition accepts a condition, FORMAT and INFO array conditions are passed with all their values
+	typeCondition
+)
+
+var typeNames = map[int]string{
+	typeAny:       "any",
+	typeNumber:    "number",
+	typeString:    "string",
+	typeBool:      "boolean",
+	typeArray:     "array",
+	typeField:     "field",
+	typeCondition: "condition",
+}
+
+// regexCache keeps compiled regular expressions of match()
+var regexCache sync.Map
+
+// FilterFunctions содержит функции для фильтрации
+var FilterFunctions = map[string]FilterFunction{
+	"has": {
+		params:  []int{typeField},
+		returns: typeBool,
+		call: func(row *VCFRow, args []any) (any, error) {
+			return row.hasField(args[0].(*fieldNode)), nil
+		},
+	},
+	"is_missing": {
+		params:  []int{typeField},
+		returns: typeBool,
+		call: func(row *VCFRow, args []any) (any, error) {
+			// The value is read without marking, so the missing value policy does not apply to is_missing()
+			value, err := args[0].(*fieldNode).value(&evalContext{row: row})
+			if err != nil {
+				return nil, err
+			}
+			return mapValues(value, func(v any) (any, error) {
+				return v == nil, nil
+			})
+		},
+	},
+	"contains": {
+		params:  []int{typeAny, typeAny},
+		returns: typeBool,
+		call: func(row *VCFRow, args []any) (any, error) {
+			if args[0] == nil || args[1] == nil {
+				return false, nil
+			}
+			if s, ok := args[0].(string); ok {
+				substring, ok := args[1].(string)
+				if !ok {
+					return nil, fmt.Errorf("second argument must be string, got %s", valueTypeName(args[1]))
+				}
+				return strings.Contains(s, substring), nil
+			}
+			return arrayContains(toArray(args[0]), args[1]), nil
+		},
+	},
+	"match": {
+		params:  []int{typeString, typeString},
+		returns: typeBool,
+		call: func(row *VCFRow, args []any) (any, error) {
+			if args[0] == nil {
+				return false, nil
+			}
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("first argument must be string, got %s", valueTypeName(args[0]))
+			}
+			pattern, ok := args[1].(string)
+			if !ok {
+				return nil, fmt.Errorf("second argument must be string, got %s", valueTypeName(args[1]))
+			}
+			re, err := compileRegex(pattern)
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(s), nil
+		},
+	},
+	"strlen": {
+		params:  []int{typeString},
+		returns: typeNumber,
+		call: func(row *VCFRow, args []any) (any, error) {
+			if args[0] == nil {
+				return nil, nil
+			}
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("argument must be string, got %s", valueTypeName(args[0]))
+			}
+			return float64(utf8.RuneCountInString(s)), nil
+		},
+	},
+	"abs": {
+		params:  []int{typeNumber},
+		returns: typeNumber,
+		call: func(row *VCFRow, args []any) (any, error) {
+			if args[0] == nil {
+				return nil, nil
+			}
+			number, ok := toNumber(args[0])
+			if !ok {
+				return nil, fmt.Errorf("argument must be number, got %s", valueTypeName(args[0]))
+			}
+			return math.Abs(number), nil
+		},
+	},
+	"min": {
+		params:  []int{typeArray},
+		returns: typeNumber,
+		call: func(row *VCFRow, args []any) (any, error) {
+			return reduceNumbers(args[0], func(numbers []float64) float64 {
+				return slices.Min(numbers)
+			})
+		},
+	},
+	"max": {
+		params:  []int{typeArray},
+		returns: typeNumber,
+		call: func(row *VCFRow, args []any) (any, error) {
+			return reduceNumbers(args[0], func(numbers []float64) float64 {
+				return slices.Max(numbers)
+			})
+		},
+	},
+	"sum": {
+		params:  []int{typeArray},
+		returns: typeNumber,
+		call: func(row *VCFRow, args []any) (any, error) {
+			return reduceNumbers(args[0], func(numbers []float64) float64 {
+				sum := 0.0
+				for _, number := range numbers {
+					sum += number
+				}
+				return sum
+			})
+		},
+	},
+	"avg": {
+		params:  []int{typeArray},
+		returns: typeNumber,
+		call: func(row *VCFRow, args []any) (any, error) {
+			return reduceNumbers(args[0], func(numbers []float64) float64 {
+				sum := 0.0
+				for _, number := range numbers {
+					sum += number
+				}
+				return sum / float64(len(numbers))
+			})
+		},
+	},
+	"is_snp": {
+		returns: typeBool,
+		call: func(row *VCFRow, args []any) (any, error) {
+			return variantType(row.Ref, row.Alt) == "snp", nil
+		},
+	},
+	"is_indel": {
+		returns: typeBool,
+		call: func(row *VCFRow, args []any) (any, error) {
+			return slices.Contains(strings.Split(variantType(row.Ref, row.Alt), ","), "indel"), nil
+		},
+	},
+	"is_multiallelic": {
+		returns: typeBool,
+		call: func(row *VCFRow, args []any) (any, error) {
+			return strings.Contains(row.Alt, ","), nil
+		},
+	},
+	"any": {
+		params:  []int{typeCondition},
+		returns: typeBool,
+		call: func(row *VCFRow, args []any) (any, error) {
+			passed, unknown, _, err := countPassed(args[0])
+			if passed == 0 && unknown > 0 {
+				return unknownValue{}, err
+			}
+			return passed > 0, err
+		},
+	},
+	"all": {
+		params:  []int{typeCondition},
+		returns: typeBool,
+		call: func(row *VCFRow, args []any) (any, error) {
+			passed, unknown, total, err := countPassed(args[0])
+			if passed < total && passed+unknown == total {
+				return unknownValue{}, err
+			}
+			return passed == total, err
+		},
+	},
+	"count": {
+		params:  []int{typeCondition},
+		returns: typeNumber,
+		call: func(row *VCFRow, args []any) (any, error) {
+			passed, _, _, err := countPassed(args[0])
+			return float64(passed), err
+		},
+	},
+	"F_PASS": {
+		params:  []int{typeCondition},
+		returns: typeNumber,
+		call: func(row *VCFRow, args []any) (any, error) {
+			passed, _, total, err := countPassed(args[0])
+			if total == 0 {
+				return nil, err
+			}
+			return float64(passed) / float64(total), err
+		},
+	},
+	"in": {
+		params:  []int{typeAny, typeArray},
+		returns: typeBool,
+		call: func(row *VCFRow, args []any) (any, error) {
+			if args[0] == nil {
+				return false, nil
+			}
+			return arrayContains(toArray(args[1]), args[0]), nil
+		},
+	},
+}
+
+func init() {
+	// bcftools names of the functions
+	for _, name := range []string{"min", "max", "sum", "avg", "abs", "strlen"} {
+		FilterFunctions[strings.ToUpper(name)] = FilterFunctions[name]
+	}
+	FilterFunctions["N_PASS"] = FilterFunctions["count"]
+}
+
+// checkArguments validates the number and the types of the arguments when the expression is compiled,
+// the header gives the types of INFO and FORMAT fields and may be nil
+func (f FilterFunction) checkArguments(name string, args []exprNode, header *VCFHeader) error {
+	if len(args) != len(f.params) {
+		return fmt.Errorf("%s() expects %d arguments, got %d", name, len(f.params), len(args))
+	}
+
+	for i, param := range f.params {
+		if param == typeField {
+			if _, ok := args[i].(*fieldNode); !ok {
+				return fmt.Errorf("%s() argument %d must be a field name", name, i+1)
+			}
+			continue
+		}
+
+		argType := typeOf(args[i], header)
+		if param == typeCondition && argType == typeBool {
+			continue
+		}
+		if param == typeArray && holdsArray(args[i], header) {
+			continue
+		}
+		if param == typeAny || argType == typeAny || argType == param {
+			continue
+		}
+		return fmt.Errorf("%s() argument %d must be %s, got %s", name, i+1, typeNames[param], typeNames[argType])
+	}
+	return nil
+}
+
+// holdsArray reports whether the node may hold several values: INFO and FORMAT fields
+// and a sample of a FORMAT list field (FMT/AD[0])
+func holdsArray(node exprNode, header *VCFHeader) bool {
+	switch n := node.(type) {
+	case *fieldNode:
+		return n.scope != fieldSite
+	case *sampleIndexNode:
+		field, exists := header.field(n.field)
+		return !exists || field.IsList()
+	}
+	return false
+}
+
+// typeOf returns the type of the value of the node known before evaluation,
+// the types of INFO and FORMAT fields are known only from the header
+func typeOf(node exprNode, header *VCFHeader) int {
+	switch n := node.(type) {
+	case *numberNode, *arithmeticNode:
+		return typeNumber
+	case *stringNode:
+		return typeString
+	case *listNode:
+		return typeArray
+	case *comparisonNode, *logicalNode, *notNode, *regexNode, *genotypeNode:
+		return typeBool
+	case *sampleIndexNode:
+		return typeOf(n.field, header)
+	case *valueIndexNode:
+		return typeOf(n.field, header)
+	case *callNode:
+		return n.function.returns
+	case *fieldNode:
+		if n.scope == fieldSite {
+			switch n.name {
+			case "POS", "QUAL", "N_ALT", "N_SAMPLES":
+				return typeNumber
+			}
+			return typeString
+		}
+		if field, exists := header.field(n); exists {
+			switch field.Type {
+			case "Integer", "Float":
+				return typeNumber
+			case "String", "Character":
+				return typeString
+			case "Flag":
+				return typeBool
+			}
+		}
+	}
+	return typeAny
+}
+
+// isCollectionType reports whether the parameter receives the values of all samples at once
+func isCollectionType(param int) bool {
+	return param == typeArray || param == typeCondition
+}
+
+// countPassed counts true and unknown values of a condition evaluated for each sample or for each INFO value.
+// Unknown values are the comparisons with missing values when a missing value policy is set
+func countPassed(value any) (int, int, int, error) {
+	values := toArray(value)
+	passed, unknown := 0, 0
+	for _, v := range values {
+		condition, err := toCondition(v)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		switch condition {
+		case conditionTrue:
+			passed++
+		case conditionUnknown:
+			unknown++
+		}
+	}
+	return passed, unknown, len(values), nil
+}
+
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression '%s': %v", pattern, err)
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// toArray converts a value to a list of values
+func toArray(value any) []any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []any:
+		return v
+	case sampleValues:
+		return v
+	case arrayValues:
+		return v
+	}
+	return []any{value}
+}
+
+// flattenArray replaces INFO and FORMAT lists in the array with their values
+func flattenArray(array []any) []any {
+	if !slices.ContainsFunc(array, func(item any) bool { _, ok := item.(arrayValues); return ok }) {
+		return array
+	}
+	flat := make([]any, 0, len(array))
+	for _, item := range array {
+		if values, ok := item.(arrayValues); ok {
+			flat = append(flat, values...)
+			continue
+		}
+		flat = append(flat, item)
+	}
+	return flat
+}
+
+func arrayContains(array []any, value any) bool {
+	for _, item := range array {
+		if equal, err := compareValues("==", item, value); err == nil && equal == true {
+			return true
+		}
+	}
+	return false
+}
+
+// reduceNumbers applies a function to the numbers of the array, missing values are skipped.
+// The values of FORMAT list fields (FMT/AD) of all samples are reduced together
+func reduceNumbers(value any, function func([]float64) float64) (any, error) {
+	var numbers []float64
+	for _, item := range flattenArray(toArray(value)) {
+		if item == nil {
+			continue
+		}
+		number, ok := toNumber(item)
+		if !ok {
+			return nil, fmt.Errorf("array must contain numbers, got %s", valueTypeName(item))
+		}
+		numbers = append(numbers, number)
+	}
+	if len(numbers) == 0 {
+		return nil, nil
+	}
+	return function(numbers), nil
+}
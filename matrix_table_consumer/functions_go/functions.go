@@ -3,27 +3,15 @@ package functions_go
 import (
 	"C"
 	"bufio"
+	"compress/gzip"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 )
 import "os"
 
-func getTime() string {
-	return time.Now().Format("02-01-2006 15:04:05")
-}
-
-func LoggerInfo(s string) {
-	t := getTime()
-	fmt.Printf("[%s] - INFO - %s", t, s)
-}
-func LoggerError(s string) {
-	t := getTime()
-	fmt.Printf("[%s] - ERROR - %s", t, s)
-}
-
 func extractRow(line string) *VCFRowJSON {
 	fields := strings.Split(strings.TrimSpace(line), "\t")
 
@@ -76,3 +64,55 @@ func GetFileSizeMB(filename string) (float64, error) {
 
 	return sizeMB, nil
 }
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// OpenVCF opens a .vcf or .vcf.gz file for reading
+func OpenVCF(vcf_path string) (*VCFReader, error) {
+	f, err := os.Open(vcf_path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the file: %v", err)
+	}
+
+	var size int64 = -1
+	if fileInfo, err := f.Stat(); err == nil {
+		size = fileInfo.Size()
+	}
+
+	counter := &countingReader{reader: f}
+	vcfReader := &VCFReader{
+		file:    f,
+		counter: counter,
+		Size:    size,
+	}
+
+	var reader io.Reader = counter
+	if strings.HasSuffix(vcf_path, ".gz") {
+		gr, err := gzip.NewReader(counter)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error creating gzip reader: %v", err)
+		}
+		vcfReader.gz = gr
+		reader = gr
+	}
+	vcfReader.Reader = bufio.NewReader(reader)
+
+	return vcfReader, nil
+}
+
+// BytesRead returns the number of bytes read from the file on disk
+func (r *VCFReader) BytesRead() int64 {
+	return r.counter.count
+}
+
+func (r *VCFReader) Close() error {
+	if r.gz != nil {
+		r.gz.Close()
+	}
+	return r.file.Close()
+}
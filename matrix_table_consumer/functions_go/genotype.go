@@ -0,0 +1,123 @@
+package functions_go
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseGenotype splits a GT value into allele indexes, missing alleles are -1
+func parseGenotype(gt string) ([]int, bool) {
+	phased := strings.Contains(gt, "|")
+	parts := strings.FieldsFunc(gt, func(r rune) bool {
+		return r == '/' || r == '|'
+	})
+
+	alleles := make([]int, 0, len(parts))
+	for _, part := range parts {
+		allele, err := strconv.Atoi(part)
+		if err != nil {
+			allele = -1
+		}
+		alleles = append(alleles, allele)
+	}
+	return alleles, phased
+}
+
+// remapGenotype replaces the allele indexes of the GT subfield of a sample, missing alleles are kept
+func remapGenotype(sample string, gtIndex int, mapping []int) string {
+	values := strings.Split(sample, ":")
+	if gtIndex < 0 || gtIndex >= len(values) {
+		return sample
+	}
+
+	gt := values[gtIndex]
+	var builder strings.Builder
+	start := 0
+	for i := 0; i <= len(gt); i++ {
+		if i < len(gt) && gt[i] != '/' && gt[i] != '|' {
+			continue
+		}
+		allele := gt[start:i]
+		if index, err := strconv.Atoi(allele); err == nil && index >= 0 && index < len(mapping) {
+			allele = strconv.Itoa(mapping[index])
+		}
+		builder.WriteString(allele)
+		if i < len(gt) {
+			builder.WriteByte(gt[i])
+		}
+		start = i + 1
+	}
+
+	values[gtIndex] = builder.String()
+	return strings.Join(values, ":")
+}
+
+// matchGenotype compares a GT value with a bcftools genotype keyword
+// (ref, alt, het, hom, hap, mis, RR, AA, RA, AR, Aa) or with a literal genotype such as 0/1
+func matchGenotype(gt string, keyword string) bool {
+	alleles, _ := parseGenotype(gt)
+	if len(alleles) == 0 {
+		return strings.ToLower(keyword) == "mis"
+	}
+
+	missing := slices.Contains(alleles, -1)
+	hasRef := slices.Contains(alleles, 0)
+	hasAlt := slices.ContainsFunc(alleles, func(allele int) bool { return allele > 0 })
+	homozygous := !missing && len(alleles) > 1 && !slices.ContainsFunc(alleles, func(allele int) bool {
+		return allele != alleles[0]
+	})
+	heterozygous := !missing && len(alleles) > 1 && !homozygous
+
+	switch keyword {
+	case "RR":
+		return homozygous && alleles[0] == 0
+	case "AA":
+		return homozygous && alleles[0] > 0
+	case "RA", "AR":
+		return heterozygous && hasRef
+	case "Aa":
+		return heterozygous && !hasRef
+	}
+
+	switch strings.ToLower(keyword) {
+	case "ref":
+		return !missing && !hasAlt
+	case "alt":
+		return hasAlt
+	case "het":
+		return heterozygous
+	case "hom":
+		return homozygous
+	case "hap":
+		return !missing && len(alleles) == 1
+	case "mis":
+		return missing
+	}
+
+	literal, phased := parseGenotype(keyword)
+	if phased {
+		return gt == keyword
+	}
+	if len(literal) != len(alleles) {
+		return false
+	}
+	slices.Sort(literal)
+	sorted := slices.Clone(alleles)
+	slices.Sort(sorted)
+	return slices.Equal(literal, sorted)
+}
+
+// isGenotypeKeyword reports whether the value can be compared with GT
+func isGenotypeKeyword(value string) bool {
+	switch value {
+	case "RR", "AA", "RA", "AR", "Aa":
+		return true
+	}
+	switch strings.ToLower(value) {
+	case "ref", "alt", "het", "hom", "hap", "mis":
+		return true
+	}
+	alleles, _ := parseGenotype(value)
+	return len(alleles) > 0 && strings.Trim(value, "0123456789./|") == ""
+}
@@ -0,0 +1,124 @@
+package functions_go
+
+import (
+	"strings"
+)
+
+func NewVCFHeader() *VCFHeader {
+	return &VCFHeader{
+		Info:   make(map[string]HeaderField),
+		Format: make(map[string]HeaderField),
+	}
+}
+
+// ParseLine adds an ##INFO, ##FORMAT or #CHROM line to the header, other lines are ignored
+func (h *VCFHeader) ParseLine(line string) {
+	switch {
+	case strings.HasPrefix(line, "##INFO=<"):
+		field := parseHeaderField(line[len("##INFO=<"):])
+		if field.ID != "" {
+			h.Info[field.ID] = field
+		}
+	case strings.HasPrefix(line, "##FORMAT=<"):
+		field := parseHeaderField(line[len("##FORMAT=<"):])
+		if field.ID != "" {
+			h.Format[field.ID] = field
+		}
+	case strings.HasPrefix(line, "#CHROM"):
+		columns := strings.Split(line, "\t")
+		if len(columns) > 9 {
+			h.Samples = columns[9:]
+		}
+	}
+}
+
+// info returns the definition of an INFO field, the header may be nil
+func (h *VCFHeader) info(name string) (HeaderField, bool) {
+	if h == nil {
+		return HeaderField{}, false
+	}
+	field, exists := h.Info[name]
+	return field, exists
+}
+
+// format returns the definition of a FORMAT field, the header may be nil
+func (h *VCFHeader) format(name string) (HeaderField, bool) {
+	if h == nil {
+		return HeaderField{}, false
+	}
+	field, exists := h.Format[name]
+	return field, exists
+}
+
+// field returns the definition of an INFO or FORMAT field of the expression, the header may be nil
+func (h *VCFHeader) field(node *fieldNode) (HeaderField, bool) {
+	if h == nil {
+		return HeaderField{}, false
+	}
+	var field HeaderField
+	exists := false
+	switch node.scope {
+	case fieldInfo:
+		field, exists = h.Info[node.name]
+	case fieldFormat:
+		field, exists = h.Format[node.name]
+	}
+	return field, exists
+}
+
+// IsList reports whether the field holds a comma separated list of values (Number=A, R, G, . or greater than 1)
+func (f HeaderField) IsList() bool {
+	return f.Number != "0" && f.Number != "1"
+}
+
+// IsNumeric reports whether the values of the field are numbers
+func (f HeaderField) IsNumeric() bool {
+	return f.Type == "Integer" || f.Type == "Float"
+}
+
+// parseHeaderField parses the content of <...> of a header line, Description may contain commas in quotes
+func parseHeaderField(content string) HeaderField {
+	content = strings.TrimSuffix(content, ">")
+
+	values := make(map[string]string)
+	var key, value strings.Builder
+	inKey, quoted, escaped := true, false, false
+	flush := func() {
+		if key.Len() > 0 {
+			values[key.String()] = value.String()
+		}
+		key.Reset()
+		value.Reset()
+		inKey = true
+	}
+
+	for _, r := range content {
+		switch {
+		case escaped:
+			value.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+			value.WriteRune(r)
+		case r == ',':
+			flush()
+		case inKey && r == '=':
+			inKey = false
+		case inKey:
+			key.WriteRune(r)
+		default:
+			value.WriteRune(r)
+		}
+	}
+	flush()
+
+	return HeaderField{
+		ID:          values["ID"],
+		Number:      values["Number"],
+		Type:        values["Type"],
+		Description: values["Description"],
+	}
+}
@@ -0,0 +1,141 @@
+package functions_go
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+	LevelOff
+)
+
+var levelNames = map[int]string{
+	LevelDebug:   "DEBUG",
+	LevelInfo:    "INFO",
+	LevelWarning: "WARNING",
+	LevelError:   "ERROR",
+	LevelOff:     "OFF",
+}
+
+var logger = struct {
+	sync.Mutex
+	writer     io.Writer
+	file       *os.File
+	level      int
+	jsonFormat bool
+}{
+	writer: os.Stderr,
+	level:  LevelInfo,
+}
+
+type logRecord struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+func getTime() string {
+	return time.Now().Format("02-01-2006 15:04:05")
+}
+
+// parseLogLevel converts a level name (debug, info, warning, error, off) to a level
+func parseLogLevel(level string) (int, error) {
+	level = strings.ToUpper(strings.TrimSpace(level))
+	if level == "WARN" {
+		level = "WARNING"
+	}
+	for value, name := range levelNames {
+		if name == level {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level '%s'", level)
+}
+
+// SetLogger configures the logger.
+// output is "stderr" (default if empty), "stdout" or a file path to append logs to.
+func SetLogger(level string, json_format bool, output string) error {
+	levelValue, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+
+	var writer io.Writer
+	var file *os.File
+	switch output {
+	case "", "stderr":
+		writer = os.Stderr
+	case "stdout":
+		writer = os.Stdout
+	default:
+		file, err = os.OpenFile(output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open the log file: %v", err)
+		}
+		writer = file
+	}
+
+	logger.Lock()
+	defer logger.Unlock()
+
+	if logger.file != nil {
+		logger.file.Close()
+	}
+	logger.writer = writer
+	logger.file = file
+	logger.level = levelValue
+	logger.jsonFormat = json_format
+
+	return nil
+}
+
+func writeLog(level int, s string) {
+	logger.Lock()
+	defer logger.Unlock()
+
+	if level < logger.level {
+		return
+	}
+
+	s = strings.TrimRight(s, "\n")
+	if logger.jsonFormat {
+		record := logRecord{
+			Time:    time.Now().Format(time.RFC3339),
+			Level:   levelNames[level],
+			Message: s,
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return
+		}
+		fmt.Fprintln(logger.writer, string(data))
+		return
+	}
+
+	fmt.Fprintf(logger.writer, "[%s] - %s - %s\n", getTime(), levelNames[level], s)
+}
+
+func LoggerDebug(s string) {
+	writeLog(LevelDebug, s)
+}
+
+func LoggerInfo(s string) {
+	writeLog(LevelInfo, s)
+}
+
+func LoggerWarning(s string) {
+	writeLog(LevelWarning, s)
+}
+
+func LoggerError(s string) {
+	writeLog(LevelError, s)
+}
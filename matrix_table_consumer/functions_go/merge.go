@@ -2,17 +2,13 @@ package functions_go
 
 import (
 	"bufio"
-	"compress/gzip"
+	"container/heap"
 	"fmt"
 	"io"
-	"maps"
 	"os"
-	"runtime"
-	"slices"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 )
 
 func newVCFRecordWithSamples(chrom, pos, id, ref, alt, qual, filter, info, format string) *VCFRecordWithSamples {
@@ -38,14 +34,12 @@ func (r *VCFRecordWithSamples) String() string {
 	return fmt.Sprintf("%s:%s", r.Chrom, r.Pos)
 }
 
-// contains checks if a string is present in a slice
-func contains(slice []string, item string) bool {
-	return slices.Contains(slice, item)
-}
-
 // parseVCFLine parses a VCF string
 func parseVCFLine(line string, sampleNames []string) *VCFRecordWithSamples {
 	parts := strings.Split(strings.TrimSpace(line), "\t")
+	for len(parts) < 9 {
+		parts = append(parts, ".")
+	}
 
 	record := newVCFRecordWithSamples(
 		parts[0],
@@ -69,352 +63,152 @@ func parseVCFLine(line string, sampleNames []string) *VCFRecordWithSamples {
 	return record
 }
 
-// readVCFHeaders reads headers from two VCF files
-func readVCFHeaders(vcf1, vcf2 string, vcf_files []string) ([]string, error) {
-	headers := make([]string, 0)
-	samplesNames := make([]string, 0)
-	var endHeaderWithoutSamples []string
+// mergeInputHeap orders the inputs by the position of their current records, inputs at the same position
+// are in the order of the command line
+type mergeInputHeap []*mergeInput
 
-	if len(vcf_files) > 0 {
-		for _, vcf_path := range vcf_files {
-			file, err := os.Open(vcf_path)
-			if err != nil {
-				return nil, err
-			}
-			defer file.Close()
-
-			var reader *bufio.Reader
-			if strings.HasSuffix(vcf1, ".gz") {
-				gr, err := gzip.NewReader(file)
-				if err != nil {
-					s := fmt.Sprintf("Error creating gzip reader: %v\n", err)
-					LoggerError(s)
-				}
-				defer gr.Close()
-				reader = bufio.NewReader(gr)
-			} else {
-				reader = bufio.NewReader(file)
-			}
+func (h mergeInputHeap) Len() int      { return len(h) }
+func (h mergeInputHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h mergeInputHeap) Less(i, j int) bool {
+	if order := compareContigKeys(h[i].contig, h[j].contig); order != 0 {
+		return order < 0
+	}
+	if h[i].pos != h[j].pos {
+		return h[i].pos < h[j].pos
+	}
+	return h[i].index < h[j].index
+}
+func (h *mergeInputHeap) Push(x any) { *h = append(*h, x.(*mergeInput)) }
+func (h *mergeInputHeap) Pop() any {
+	old := *h
+	input := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return input
+}
 
-			scanner := GetScaner(reader)
-			for scanner.Scan() {
-				line := scanner.Text()
-				if strings.HasPrefix(line, "##") {
-					if !contains(headers, line) {
-						headers = append(headers, line)
-					}
-				} else if strings.HasPrefix(line, "#CHROM") {
-					headerEnd := strings.Split(strings.TrimSpace(line), "\t")
-					endHeaderWithoutSamples = headerEnd[:9]
-					samplesNames = append(samplesNames, headerEnd[9:]...)
-					break
-				}
-			}
-		}
-	} else {
-		// Reading the first file
-		file1, err := os.Open(vcf1)
+// openMergeInputs opens the inputs and reads their headers. The ## lines of all inputs are kept once,
+// the samples of all inputs are sorted by name. The ##contig lines are added to the contig order
+func openMergeInputs(paths []string, order *ContigOrder) ([]*mergeInput, []string, []string, error) {
+	var inputs []*mergeInput
+	var headers []string
+	var columns []string
+	seenHeaders := make(map[string]bool)
+	seenSamples := make(map[string]bool)
+	var samples []string
+
+	for i, path := range paths {
+		reader, err := OpenVCF(path)
 		if err != nil {
-			return nil, err
+			closeMergeInputs(inputs)
+			return nil, nil, nil, err
 		}
-		defer file1.Close()
-
-		var reader1 *bufio.Reader
-		if strings.HasSuffix(vcf1, ".gz") {
-			gr, err := gzip.NewReader(file1)
-			if err != nil {
-				s := fmt.Sprintf("Error creating gzip reader: %v\n", err)
-				LoggerError(s)
+		input := &mergeInput{path: path, reader: reader, index: i}
+		inputs = append(inputs, input)
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				closeMergeInputs(inputs)
+				return nil, nil, nil, fmt.Errorf("failed to read %s: %v", path, err)
 			}
-			defer gr.Close()
-			reader1 = bufio.NewReader(gr)
-		} else {
-			reader1 = bufio.NewReader(file1)
-		}
+			line = strings.TrimRight(line, "\r\n")
 
-		scanner1 := GetScaner(reader1)
-		for scanner1.Scan() {
-			line := scanner1.Text()
 			if strings.HasPrefix(line, "##") {
-				if !contains(headers, line) {
+				if !seenHeaders[line] {
+					seenHeaders[line] = true
 					headers = append(headers, line)
+					order.ParseHeaderLine(line)
 				}
 			} else if strings.HasPrefix(line, "#CHROM") {
-				headerEnd := strings.Split(strings.TrimSpace(line), "\t")
-				endHeaderWithoutSamples = headerEnd[:9]
-				samplesNames = append(samplesNames, headerEnd[9:]...)
+				parts := strings.Split(strings.TrimSpace(line), "\t")
+				if columns == nil {
+					columns = parts[:min(len(parts), 9)]
+				}
+				input.samples = parts[min(len(parts), 9):]
+				for _, sample := range input.samples {
+					if !seenSamples[sample] {
+						seenSamples[sample] = true
+						samples = append(samples, sample)
+					}
+				}
 				break
 			}
-		}
 
-		// Reading the second file
-		file2, err := os.Open(vcf2)
-		if err != nil {
-			return nil, err
-		}
-		defer file2.Close()
-
-		var reader2 *bufio.Reader
-		if strings.HasSuffix(vcf2, ".gz") {
-			gr, err := gzip.NewReader(file2)
-			if err != nil {
-				s := fmt.Sprintf("Error creating gzip reader: %v\n", err)
-				LoggerError(s)
-			}
-			defer gr.Close()
-			reader2 = bufio.NewReader(gr)
-		} else {
-			reader2 = bufio.NewReader(file2)
-		}
-
-		scanner2 := GetScaner(reader2)
-		for scanner2.Scan() {
-			line := scanner2.Text()
-			if strings.HasPrefix(line, "##") {
-				if !contains(headers, line) {
-					headers = append(headers, line)
-				}
-			} else if strings.HasPrefix(line, "#CHROM") {
-				headerEnd := strings.Split(strings.TrimSpace(line), "\t")
-				samplesNames = append(samplesNames, headerEnd[9:]...)
-				break
+			if err == io.EOF {
+				closeMergeInputs(inputs)
+				return nil, nil, nil, fmt.Errorf("%s has no #CHROM header line", path)
 			}
 		}
 	}
 
 	// Sorting sample names
-	sort.Strings(samplesNames)
+	sort.Strings(samples)
 
 	// Formation of final headings
-	endHeaderStr := strings.Join(endHeaderWithoutSamples, "\t") + "\t"
-	endHeaderStr += strings.Join(samplesNames, "\t") + "\n"
-	headers = append(headers, endHeaderStr)
+	headers = append(headers, strings.Join(append(columns, samples...), "\t"))
 
-	return headers, nil
+	return inputs, headers, samples, nil
 }
 
-// readAndMergeVCFs reads and merges two VCF files with streaming processing for large files
-func readVCFs(vcf1, vcf2 string, vcf_files []string) ([]*VCFRecordWithSamples, []string, error) {
-	allSamples := make(map[string]bool)
-
-	recordChan := make(chan *VCFRecordWithSamples, 5_000)
-	errorChan := make(chan error, 2)
-	doneChan := make(chan bool, 1)
-
-	var wg sync.WaitGroup
-	wg.Add(1)
+func closeMergeInputs(inputs []*mergeInput) {
+	for _, input := range inputs {
+		input.reader.Close()
+	}
+}
 
-	go func() {
-		defer wg.Done()
-		defer close(recordChan)
+// next reads the next record of the input, the record is nil at the end of the input.
+// An error is returned if the input is not sorted in the contig order
+func (input *mergeInput) next(order *ContigOrder) error {
+	previous := input.record
+	for {
+		line, err := input.reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read %s: %v", input.path, err)
+		}
 
-		processFile := func(file_path string) error {
-			file, err := os.Open(file_path)
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			record := parseVCFLine(line, input.samples)
+			record.input = input.index
+			pos, err := strconv.Atoi(record.Pos)
 			if err != nil {
-				return err
+				return fmt.Errorf("invalid position %s:%s in %s", record.Chrom, record.Pos, input.path)
 			}
-			defer file.Close()
-
-			var reader io.Reader = file
-			if strings.HasSuffix(file_path, ".gz") {
-				gr, err := gzip.NewReader(file)
-				if err != nil {
-					return fmt.Errorf("gzip error: %v", err)
-				}
-				defer gr.Close()
-				reader = gr
-			}
-
-			scanner := bufio.NewScanner(reader)
-			const maxTokenSize = 1 << 21
-			buf := make([]byte, maxTokenSize)
-			scanner.Buffer(buf, maxTokenSize)
-			var sampleNames []string
-			recordCount := 0
-
-			for scanner.Scan() {
-				line := scanner.Text()
-				if strings.HasPrefix(line, "#CHROM") {
-					sampleNames = strings.Split(strings.TrimSpace(line), "\t")[9:]
-					continue
-				} else if strings.HasPrefix(line, "#") {
-					continue
-				} else if strings.TrimSpace(line) == "" {
-					break
-				}
-
-				record := parseVCFLine(line, sampleNames)
-				recordChan <- record
-
-				for sample := range record.Samples {
-					allSamples[sample] = true
-				}
 
-				recordCount++
-				if recordCount%10_000 == 0 {
-					runtime.Gosched()
-				}
+			contig := input.contig
+			if previous == nil || record.Chrom != previous.Chrom {
+				contig = order.Key(record.Chrom)
 			}
-			return scanner.Err()
-		}
-
-		if len(vcf_files) > 0 {
-			for _, file_path := range vcf_files {
-				if err := processFile(file_path); err != nil {
-					errorChan <- err
-					return
+			if previous != nil {
+				contigOrder := compareContigKeys(contig, input.contig)
+				if contigOrder < 0 || contigOrder == 0 && pos < input.pos {
+					return fmt.Errorf("%s is not sorted: %s:%d after %s:%d, sort it with Sort before merging",
+						input.path, record.Chrom, pos, previous.Chrom, input.pos)
 				}
 			}
-		} else {
-			if err := processFile(vcf1); err != nil {
-				errorChan <- err
-				return
-			}
 
-			if err := processFile(vcf2); err != nil {
-				errorChan <- err
-				return
-			}
+			input.record, input.contig, input.pos = record, contig, pos
+			return nil
 		}
 
-		doneChan <- true
-	}()
-
-	records := make([]*VCFRecordWithSamples, 0)
-	processing := true
-	for processing {
-		select {
-		case record, ok := <-recordChan:
-			if !ok {
-				processing = false
-				break
-			}
-
-			records = append(records, record)
-
-		case err := <-errorChan:
-			return nil, nil, err
-
-		case <-doneChan:
-			// We continue to process the remaining records in the channel
+		if err == io.EOF {
+			input.record = nil
+			return nil
 		}
 	}
-
-	wg.Wait()
-
-	samplesList := make([]string, 0, len(allSamples))
-	for sample := range allSamples {
-		samplesList = append(samplesList, sample)
-	}
-	sort.Strings(samplesList)
-
-	return records, samplesList, nil
-}
-
-func mergeRecords(records []*VCFRecordWithSamples) ([]*VCFRecordWithSamples, error) {
-	if len(records) == 0 {
-		return nil, nil
-	}
-
-	// Group records by key (Chrom, Pos)
-	recordsByKey := make(map[[2]string][]*VCFRecordWithSamples)
-	for _, record := range records {
-		key := [2]string{record.Chrom, record.Pos}
-		recordsByKey[key] = append(recordsByKey[key], record)
-	}
-
-	// Sorting keys for sequential processing
-	keys := make([][2]string, 0, len(recordsByKey))
-	for key := range recordsByKey {
-		keys = append(keys, key)
-	}
-
-	// Sort keys by chromosome and position
-	sort.Slice(keys, func(i, j int) bool {
-		if keys[i][0] != keys[j][0] {
-			return keys[i][0] < keys[j][0]
-		}
-
-		posI, errI := strconv.Atoi(keys[i][1])
-		posJ, errJ := strconv.Atoi(keys[j][1])
-		if errI != nil || errJ != nil {
-			return keys[i][1] < keys[j][1] // lexicographic comparison
-		}
-		return posI < posJ
-	})
-
-	// Merge records for each key
-	var mergedRecords []*VCFRecordWithSamples
-	for _, key := range keys {
-		recordsWithSameKey := recordsByKey[key]
-		mergedRecord := mergeRecordsForKey(key, recordsWithSameKey)
-		if mergedRecord != nil {
-			mergedRecords = append(mergedRecords, mergedRecord)
-		}
-	}
-
-	return mergedRecords, nil
-}
-
-// mergeRecordsForKey merges all records for a specific key
-func mergeRecordsForKey(key [2]string, records []*VCFRecordWithSamples) *VCFRecordWithSamples {
-	if len(records) == 0 {
-		return nil
-	}
-
-	firstRecord := records[0]
-	mergedRecord := newVCFRecordWithSamples(
-		firstRecord.Chrom,
-		key[1],
-		firstRecord.ID,
-		firstRecord.Ref,
-		firstRecord.Alt,
-		firstRecord.Qual,
-		firstRecord.Filter,
-		firstRecord.Info,
-		firstRecord.Format,
-	)
-
-	for _, record := range records {
-		maps.Copy(mergedRecord.Samples, record.Samples)
-	}
-	return mergedRecord
 }
 
 // writeHeaders writes headers to the output file
-func writeHeaders(headerLines []string, outputFile string) error {
-	file, err := os.Create(outputFile)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := bufio.NewWriter(file)
-	headerLinesLen := len(headerLines)
-	for i, line := range headerLines {
-		if i == headerLinesLen-1 {
-			_, err := writer.WriteString(line)
-			if err != nil {
-				return err
-			}
-		} else {
-			if _, err := writer.WriteString(line + "\n"); err != nil {
-				return err
-			}
+func writeHeaders(headerLines []string, writer *bufio.Writer) error {
+	for _, line := range headerLines {
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			return err
 		}
 	}
-	return writer.Flush()
+	return nil
 }
 
 // writeMergedRecords writes merged records to a file
-func writeMergedRecord(record *VCFRecordWithSamples, samplesOrdered []string, outputFile string) error {
-	file, err := os.OpenFile(outputFile, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := bufio.NewWriter(file)
+func writeMergedRecord(record *VCFRecordWithSamples, samplesOrdered []string, writer *bufio.Writer) error {
 	columns := []string{
 		record.Chrom,
 		record.Pos,
@@ -427,9 +221,10 @@ func writeMergedRecord(record *VCFRecordWithSamples, samplesOrdered []string, ou
 		record.Format,
 	}
 
+	missing := missingSample(record.Format)
 	var sampleValues []string
 	for _, sample := range samplesOrdered {
-		sampleData := "./."
+		sampleData := missing
 		if val, exists := record.Samples[sample]; exists {
 			sampleData = val
 		}
@@ -437,16 +232,89 @@ func writeMergedRecord(record *VCFRecordWithSamples, samplesOrdered []string, ou
 	}
 
 	columns = append(columns, sampleValues...)
-	if _, err := writer.WriteString(strings.Join(columns, "\t") + "\n"); err != nil {
-		return err
+	_, err := writer.WriteString(strings.Join(columns, "\t") + "\n")
+	return err
+}
+
+// mergeSortedInputs walks the sorted inputs in lockstep and writes each position as soon as
+// all inputs have passed it, only the current records of the inputs are kept in memory
+func mergeSortedInputs(inputs []*mergeInput, samples []string, order *ContigOrder, mode string, rules *mergeRules, writer *bufio.Writer) (int, error) {
+	var totalSize int64 = 0
+	pending := make(mergeInputHeap, 0, len(inputs))
+	for _, input := range inputs {
+		totalSize += max(input.reader.Size, 0)
+		if err := input.next(order); err != nil {
+			return 0, err
+		}
+		if input.record != nil {
+			pending = append(pending, input)
+		}
+	}
+	heap.Init(&pending)
+
+	progress := NewProgress("merge", totalSize)
+	defer progress.Close()
+	bytesRead := func() int64 {
+		var bytes int64 = 0
+		for _, input := range inputs {
+			bytes += input.reader.BytesRead()
+		}
+		return bytes
 	}
-	return writer.Flush()
+
+	written := 0
+	var group []*VCFRecordWithSamples
+	for len(pending) > 0 {
+		chrom, pos := pending[0].record.Chrom, pending[0].pos
+
+		// Records of all inputs at the position, in the order of the inputs
+		group = group[:0]
+		for len(pending) > 0 && pending[0].pos == pos && pending[0].record.Chrom == chrom {
+			input := pending[0]
+			for input.record != nil && input.pos == pos && input.record.Chrom == chrom {
+				group = append(group, input.record)
+				if err := input.next(order); err != nil {
+					return written, err
+				}
+			}
+
+			if input.record == nil {
+				heap.Pop(&pending)
+			} else {
+				heap.Fix(&pending, 0)
+			}
+		}
+
+		// Records with different alleles are merged by the mode
+		for _, alleles := range groupAlleles(group, mode) {
+			if err := writeMergedRecord(alleles.mergedRecord(rules), samples, writer); err != nil {
+				return written, err
+			}
+			written++
+		}
+		progress.Update(len(group), bytesRead())
+	}
+	return written, nil
 }
 
-// Merge combines two VCF files
-func Merge(vcf1, vcf2, outputVCF, file_with_vcfs string) {
+// Merge combines coordinate-sorted VCF files: VCF1 and VCF2 or the files listed in FileWithVCFs.
+// The inputs are read in lockstep, so the memory does not depend on the size of the inputs.
+// Records at the same position are merged if they have the same alleles or if the Multiallelics mode allows it
+// and their ID, QUAL, FILTER and INFO are combined by the rules of the options
+func Merge(options MergeOptions) {
+	outputVCF, file_with_vcfs := options.OutputVCF, options.FileWithVCFs
+	mode := options.Multiallelics
+	if mode == "" {
+		mode = MergeBoth
+	}
+	if err := validMergeMode(mode); err != nil {
+		s := fmt.Sprintf("Error: %v\n", err)
+		LoggerError(s)
+		return
+	}
+
 	var vcf_files []string
-	if file_with_vcfs != "." {
+	if file_with_vcfs != "." && file_with_vcfs != "" {
 		f, err := os.Open(file_with_vcfs)
 		if err != nil {
 			s := fmt.Sprintf("Failed to open the file: %v\n", err)
@@ -468,46 +336,63 @@ func Merge(vcf1, vcf2, outputVCF, file_with_vcfs string) {
 				vcf_files = append(vcf_files, vcf_path)
 			}
 		}
+	} else {
+		vcf_files = []string{options.VCF1, options.VCF2}
 	}
 
-	headers, err := readVCFHeaders(vcf1, vcf2, vcf_files)
+	order := NewContigOrder()
+	inputs, headers, samples, err := openMergeInputs(vcf_files, order)
 	if err != nil {
 		s := fmt.Sprintf("Error: %v\n", err)
 		LoggerError(s)
+		return
 	}
+	defer closeMergeInputs(inputs)
 
-	LoggerInfo("Writing headers...\n")
-
-	if err := writeHeaders(headers, outputVCF); err != nil {
+	header := NewVCFHeader()
+	for _, line := range headers {
+		header.ParseLine(line)
+	}
+	rules, err := newMergeRules(options, header)
+	if err != nil {
 		s := fmt.Sprintf("Error: %v\n", err)
 		LoggerError(s)
+		return
 	}
 
-	LoggerInfo("Reading VCFs...\n")
-
-	records, samplesList, err := readVCFs(vcf1, vcf2, vcf_files)
+	outputFile, err := os.Create(outputVCF)
 	if err != nil {
+		s := fmt.Sprintf("Error creating output file: %v\n", err)
+		LoggerError(s)
+		return
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+
+	LoggerInfo("Writing headers...\n")
+
+	if err := writeHeaders(headers, writer); err != nil {
 		s := fmt.Sprintf("Error: %v\n", err)
 		LoggerError(s)
+		outputFile.Close()
+		os.Remove(outputVCF)
+		return
 	}
 
 	LoggerInfo("Merging records...\n")
 
-	mergedRecords, err := mergeRecords(records)
+	written, err := mergeSortedInputs(inputs, samples, order, mode, rules, writer)
+	if err == nil {
+		err = writer.Flush()
+	}
 	if err != nil {
 		s := fmt.Sprintf("Error: %v\n", err)
 		LoggerError(s)
+		outputFile.Close()
+		os.Remove(outputVCF)
+		return
 	}
 
-	bar := NewTqdm(len(mergedRecords), WithDescription("Write merged records"))
-	defer bar.Close()
-
-	for _, record := range mergedRecords {
-		err := writeMergedRecord(record, samplesList, outputVCF)
-		if err != nil {
-			s := fmt.Sprintf("Error: %v\n", err)
-			LoggerError(s)
-		}
-		bar.Increment()
-	}
+	s := fmt.Sprintf("Merged %d records from %d files\n", written, len(inputs))
+	LoggerInfo(s)
 }
@@ -0,0 +1,360 @@
+package functions_go
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Modes of Merge for records with different alleles at the same position, the same as in bcftools merge -m
+const (
+	// MergeNone merges only records with the same alleles
+	MergeNone = "none"
+	// MergeSNPs merges SNPs into multiallelic records
+	MergeSNPs = "snps"
+	// MergeIndels merges indels into multiallelic records
+	MergeIndels = "indels"
+	// MergeBoth merges SNPs with SNPs and indels with indels
+	MergeBoth = "both"
+	// MergeAll merges all records at the position into one record
+	MergeAll = "all"
+)
+
+// Kinds of records, records without ALT alleles are merged with records of any kind
+const (
+	alleleRef = iota
+	alleleSNP
+	alleleIndel
+	alleleOther
+)
+
+func validMergeMode(mode string) error {
+	switch mode {
+	case MergeNone, MergeSNPs, MergeIndels, MergeBoth, MergeAll:
+		return nil
+	}
+	return fmt.Errorf("unknown merge mode '%s', expected none, snps, indels, both or all", mode)
+}
+
+// splitAlts returns the ALT alleles, nil if there are no ALT alleles
+func splitAlts(alt string) []string {
+	if alt == "." || alt == "" {
+		return nil
+	}
+	return strings.Split(alt, ",")
+}
+
+// isSymbolicAllele reports whether the allele has no sequence: <DEL>, breakends, * and missing alleles
+func isSymbolicAllele(allele string) bool {
+	return allele == "*" || allele == "." || strings.ContainsAny(allele, "<>[]")
+}
+
+func recordAlleleKind(ref string, alts []string) int {
+	if len(alts) == 0 {
+		return alleleRef
+	}
+
+	kind := alleleSNP
+	for _, alt := range alts {
+		switch {
+		case isSymbolicAllele(alt):
+			return alleleOther
+		case len(ref) != len(alt):
+			kind = alleleIndel
+		case len(ref) != 1:
+			// MNPs
+			return alleleOther
+		}
+	}
+	return kind
+}
+
+// extendAllele appends the bases of the longer REF to an allele of a record with a shorter REF
+func extendAllele(allele string, suffix string) string {
+	if suffix == "" || isSymbolicAllele(allele) {
+		return allele
+	}
+	return allele + suffix
+}
+
+// accepts reports whether the record can be added to the group: the group must not have a record
+// of the same input (like bcftools merge), the REF alleles must be compatible (one is a prefix
+// of the other) and the mode must allow merging records of these kinds
+func (g *alleleGroup) accepts(record *VCFRecordWithSamples, alts []string, kind int, mode string) bool {
+	if slices.ContainsFunc(g.records, func(other *VCFRecordWithSamples) bool { return other.input == record.input }) {
+		return false
+	}
+	ref := record.Ref
+	if !strings.HasPrefix(ref, g.ref) && !strings.HasPrefix(g.ref, ref) {
+		return false
+	}
+	if kind == alleleRef || g.kind == alleleRef {
+		return true
+	}
+
+	switch {
+	case mode == MergeAll:
+		return true
+	case kind != g.kind:
+	case kind == alleleSNP && (mode == MergeSNPs || mode == MergeBoth):
+		return true
+	case kind == alleleIndel && (mode == MergeIndels || mode == MergeBoth):
+		return true
+	}
+	return ref == g.ref && slices.Equal(alts, g.alts)
+}
+
+// add adds the record to the group, the REF of the group is extended to the longest REF
+// and the ALT alleles of the record are added to the ALT alleles of the group
+func (g *alleleGroup) add(record *VCFRecordWithSamples, alts []string, kind int) {
+	if len(record.Ref) > len(g.ref) {
+		suffix := record.Ref[len(g.ref):]
+		for i, alt := range g.alts {
+			g.alts[i] = extendAllele(alt, suffix)
+		}
+		g.ref = record.Ref
+	}
+	if g.kind == alleleRef {
+		g.kind = kind
+	}
+
+	suffix := g.ref[len(record.Ref):]
+	mapping := make([]int, 1, len(alts)+1)
+	for _, alt := range alts {
+		alt = extendAllele(alt, suffix)
+		index := slices.Index(g.alts, alt)
+		if index < 0 {
+			g.alts = append(g.alts, alt)
+			index = len(g.alts) - 1
+		}
+		mapping = append(mapping, index+1)
+	}
+
+	g.records = append(g.records, record)
+	g.mappings = append(g.mappings, mapping)
+}
+
+// hasAlleles reports whether the group has all ALT alleles of a record with a REF not longer than the REF of the group
+func (g *alleleGroup) hasAlleles(ref string, alts []string) bool {
+	if len(ref) > len(g.ref) {
+		return false
+	}
+	suffix := g.ref[len(ref):]
+	for _, alt := range alts {
+		if !slices.Contains(g.alts, extendAllele(alt, suffix)) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupAlleles splits the records at one position into the groups merged into one record,
+// the groups are in the order of their first records
+func groupAlleles(records []*VCFRecordWithSamples, mode string) []*alleleGroup {
+	var groups []*alleleGroup
+	for _, record := range records {
+		alts := splitAlts(record.Alt)
+		kind := recordAlleleKind(record.Ref, alts)
+
+		// A group that already has the alleles of the record is preferred, so that the split records
+		// of one input are matched with the same alleles of other inputs
+		index := slices.IndexFunc(groups, func(group *alleleGroup) bool {
+			return group.accepts(record, alts, kind, mode) && group.hasAlleles(record.Ref, alts)
+		})
+		if index < 0 {
+			index = slices.IndexFunc(groups, func(group *alleleGroup) bool {
+				return group.accepts(record, alts, kind, mode)
+			})
+		}
+		if index < 0 {
+			groups = append(groups, &alleleGroup{kind: kind, ref: record.Ref})
+			index = len(groups) - 1
+		}
+		groups[index].add(record, alts, kind)
+	}
+	return groups
+}
+
+// mergeFormats returns the keys of the merged FORMAT: GT first, then the keys of the records
+// in the order of their first appearance
+func mergeFormats(records []*VCFRecordWithSamples) []string {
+	var keys []string
+	hasGT := false
+	for _, record := range records {
+		if record.Format == "." || record.Format == "" {
+			continue
+		}
+		for key := range strings.SplitSeq(record.Format, ":") {
+			if key == "GT" {
+				hasGT = true
+			} else if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	if hasGT {
+		keys = slices.Insert(keys, 0, "GT")
+	}
+	return keys
+}
+
+// harmonizeSample orders the subfields of a sample value by the merged FORMAT, indexes are the indexes
+// of the merged keys in the FORMAT of the record (-1 if the record has no such key).
+// Missing subfields are ".", a missing GT is "./."
+func harmonizeSample(value string, indexes []int, keys []string) string {
+	values := strings.Split(value, ":")
+	harmonized := make([]string, len(keys))
+	for i, index := range indexes {
+		switch {
+		case index >= 0 && index < len(values) && values[index] != "":
+			harmonized[i] = values[index]
+		case keys[i] == "GT":
+			harmonized[i] = "./."
+		default:
+			harmonized[i] = "."
+		}
+	}
+	return strings.Join(harmonized, ":")
+}
+
+// remapAlleleValues moves the per-allele values of a FORMAT subfield to the merged alleles: Number=R has a value
+// for each allele, Number=A for each ALT allele and Number=G for each genotype (haploid or diploid).
+// The values of the merged alleles that the record does not have are ".", a value that does not match
+// the number of alleles of the record cannot be remapped and is replaced with "."
+func remapAlleleValues(value string, number string, mapping []int, alleles int) string {
+	if value == "." || value == "" {
+		return value
+	}
+	items := strings.Split(value, ",")
+	var remapped []string
+
+	switch number {
+	case "R", "A":
+		offset := 0
+		if number == "A" {
+			offset = 1
+		}
+		if len(items) != len(mapping)-offset {
+			return "."
+		}
+		remapped = make([]string, alleles-offset)
+		for i, item := range items {
+			remapped[mapping[i+offset]-offset] = item
+		}
+
+	case "G":
+		switch len(items) {
+		case len(mapping):
+			// Haploid genotypes, one value per allele
+			remapped = make([]string, alleles)
+			for i, item := range items {
+				remapped[mapping[i]] = item
+			}
+		case len(mapping) * (len(mapping) + 1) / 2:
+			// Diploid genotypes j/k are ordered by k, then by j (0/0, 0/1, 1/1, 0/2, ...)
+			remapped = make([]string, alleles*(alleles+1)/2)
+			for k := range mapping {
+				for j := 0; j <= k; j++ {
+					low, high := min(mapping[j], mapping[k]), max(mapping[j], mapping[k])
+					remapped[high*(high+1)/2+low] = items[k*(k+1)/2+j]
+				}
+			}
+		default:
+			return "."
+		}
+
+	default:
+		return value
+	}
+
+	for i, item := range remapped {
+		if item == "" {
+			remapped[i] = "."
+		}
+	}
+	return strings.Join(remapped, ",")
+}
+
+// missingSample returns the value of a sample that is not in the merged records
+func missingSample(format string) string {
+	keys := strings.Split(format, ":")
+	return harmonizeSample("", slices.Repeat([]int{-1}, len(keys)), keys)
+}
+
+// mergedRecord merges the records of the group, the GT indexes and the Number=R, A and G subfields of the samples
+// are changed to the merged alleles and the subfields of the samples are ordered by the merged FORMAT.
+// ID, QUAL, FILTER and INFO are combined by the rules
+func (g *alleleGroup) mergedRecord(rules *mergeRules) *VCFRecordWithSamples {
+	firstRecord := g.records[0]
+	alt := "."
+	if len(g.alts) > 0 {
+		alt = strings.Join(g.alts, ",")
+	}
+
+	mergedRecord := newVCFRecordWithSamples(
+		firstRecord.Chrom,
+		firstRecord.Pos,
+		mergeIDs(g.records),
+		g.ref,
+		alt,
+		mergeQual(g.records, rules.qual),
+		mergeFilters(g.records, rules.filter),
+		firstRecord.Info,
+		firstRecord.Format,
+	)
+	keys := mergeFormats(g.records)
+	if len(keys) > 0 {
+		mergedRecord.Format = strings.Join(keys, ":")
+	}
+
+	// The merged GT of each sample
+	genotypes := make(map[string]string)
+	for i, record := range g.records {
+		mapping := g.mappings[i]
+		identity := len(mapping) == len(g.alts)+1
+		for allele, index := range mapping {
+			identity = identity && allele == index
+		}
+
+		recordKeys := strings.Split(record.Format, ":")
+		gtIndex := slices.Index(recordKeys, "GT")
+		// The Number of the per-allele subfields by their index in the FORMAT of the record
+		alleleFields := make(map[int]string)
+		for index, key := range recordKeys {
+			if field, exists := rules.header.format(key); exists && (field.Number == "R" || field.Number == "A" || field.Number == "G") {
+				alleleFields[index] = field.Number
+			}
+		}
+		harmonize := len(keys) > 0 && record.Format != mergedRecord.Format
+		indexes := make([]int, len(keys))
+		for i, key := range keys {
+			indexes[i] = slices.Index(recordKeys, key)
+		}
+
+		for sample, value := range record.Samples {
+			if !identity {
+				value = remapGenotype(value, gtIndex, mapping)
+				if len(alleleFields) > 0 {
+					values := strings.Split(value, ":")
+					for index, number := range alleleFields {
+						if index < len(values) {
+							values[index] = remapAlleleValues(values[index], number, mapping, len(g.alts)+1)
+						}
+					}
+					value = strings.Join(values, ":")
+				}
+			}
+			if values := strings.Split(value, ":"); gtIndex >= 0 && gtIndex < len(values) {
+				genotypes[sample] = values[gtIndex]
+			}
+			if harmonize {
+				value = harmonizeSample(value, indexes, keys)
+			}
+			mergedRecord.Samples[sample] = value
+		}
+	}
+
+	mergedRecord.Info = g.mergeInfo(rules, slices.Collect(maps.Values(genotypes)))
+	return mergedRecord
+}
@@ -0,0 +1,343 @@
+package functions_go
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Rules of Merge for QUAL, FILTER, AC/AN/AF and INFO keys
+const (
+	RuleFirst = "first"
+	RuleJoin  = "join"
+	RuleSum   = "sum"
+	RuleMin   = "min"
+	RuleMax   = "max"
+	RuleAvg   = "avg"
+
+	// FilterUnion keeps the filters of all records, PASS only if all records pass
+	FilterUnion = "union"
+	// FilterPassIfAny writes PASS if at least one record passes
+	FilterPassIfAny = "pass"
+
+	// CountsRecompute counts AC, AN and AF from the merged genotypes
+	CountsRecompute = "recompute"
+)
+
+// alleleCountTags are combined by the AlleleCounts rule
+var alleleCountTags = []string{"AC", "AN", "AF"}
+
+// newMergeRules checks the rules of the options and parses the INFO rules
+func newMergeRules(options MergeOptions, header *VCFHeader) (*mergeRules, error) {
+	rules := &mergeRules{
+		qual:         options.QualRule,
+		filter:       options.FilterRule,
+		alleleCounts: options.AlleleCounts,
+		info:         make(map[string]string),
+		header:       header,
+	}
+	if rules.qual == "" {
+		rules.qual = RuleMax
+	}
+	if rules.filter == "" {
+		rules.filter = FilterUnion
+	}
+	if rules.alleleCounts == "" {
+		rules.alleleCounts = CountsRecompute
+	}
+
+	if rules.qual != RuleMax && rules.qual != RuleMin && rules.qual != RuleAvg {
+		return nil, fmt.Errorf("unknown QUAL rule '%s', expected max, min or avg", rules.qual)
+	}
+	if rules.filter != FilterUnion && rules.filter != FilterPassIfAny {
+		return nil, fmt.Errorf("unknown FILTER rule '%s', expected union or pass", rules.filter)
+	}
+	if rules.alleleCounts != CountsRecompute && rules.alleleCounts != RuleSum {
+		return nil, fmt.Errorf("unknown AC/AN/AF rule '%s', expected recompute or sum", rules.alleleCounts)
+	}
+
+	for rule := range strings.SplitSeq(options.InfoRules, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		key, value, found := strings.Cut(rule, ":")
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid INFO rule '%s', expected KEY:rule", rule)
+		}
+		switch value {
+		case RuleFirst, RuleJoin, RuleSum, RuleMin, RuleMax, RuleAvg:
+			rules.info[key] = value
+		default:
+			return nil, fmt.Errorf("unknown rule '%s' of INFO/%s, expected first, join, sum, min, max or avg", value, key)
+		}
+	}
+	return rules, nil
+}
+
+// mergeIDs returns the IDs of all records without duplicates
+func mergeIDs(records []*VCFRecordWithSamples) string {
+	var ids []string
+	for _, record := range records {
+		for id := range strings.SplitSeq(record.ID, ";") {
+			if id != "." && id != "" && !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return "."
+	}
+	return strings.Join(ids, ";")
+}
+
+// mergeQual combines the QUAL of the records, missing QUAL values are skipped
+func mergeQual(records []*VCFRecordWithSamples, rule string) string {
+	result := "."
+	best, sum := 0.0, 0.0
+	count := 0
+	for _, record := range records {
+		qual, err := strconv.ParseFloat(record.Qual, 64)
+		if err != nil {
+			continue
+		}
+		if count == 0 || rule == RuleMax && qual > best || rule == RuleMin && qual < best {
+			best = qual
+			result = record.Qual
+		}
+		sum += qual
+		count++
+	}
+
+	if rule == RuleAvg && count > 0 {
+		return formatAverage(sum, count)
+	}
+	return result
+}
+
+// formatAverage returns the average rounded to two decimal places
+func formatAverage(sum float64, count int) string {
+	return strconv.FormatFloat(math.Round(sum/float64(count)*100)/100, 'f', -1, 64)
+}
+
+// mergeFilters combines the FILTER of the records. The union has PASS only if all records pass,
+// FilterPassIfAny writes PASS if at least one record passes
+func mergeFilters(records []*VCFRecordWithSamples, rule string) string {
+	var filters []string
+	passed := false
+	for _, record := range records {
+		if record.Filter == "PASS" {
+			passed = true
+		}
+		for filter := range strings.SplitSeq(record.Filter, ";") {
+			if filter != "." && filter != "" && filter != "PASS" && !slices.Contains(filters, filter) {
+				filters = append(filters, filter)
+			}
+		}
+	}
+
+	switch {
+	case passed && (rule == FilterPassIfAny || len(filters) == 0):
+		return "PASS"
+	case len(filters) == 0:
+		return "."
+	}
+	return strings.Join(filters, ";")
+}
+
+// infoRule returns the rule of an INFO key: the rule of the options, the per-allele rule of Number=A and
+// Number=R keys, join for the keys with any number of values and first for the other keys
+func (r *mergeRules) infoRule(key string) string {
+	if rule, exists := r.info[key]; exists {
+		return rule
+	}
+	field, exists := r.header.info(key)
+	switch {
+	case !exists:
+		return RuleFirst
+	case field.Number == "A" || field.Number == "R":
+		return field.Number
+	case field.Number == ".":
+		return RuleJoin
+	}
+	return RuleFirst
+}
+
+// mergeInfo combines the INFO of the records of the group, the keys are in the order of their first records.
+// genotypes are the merged GT values of the samples used to recompute AC, AN and AF
+func (g *alleleGroup) mergeInfo(rules *mergeRules, genotypes []string) string {
+	var keys []string
+	values := make(map[string][]string)
+	// Per-allele values are only combined for the records that have the key
+	hasKey := make(map[string][]bool)
+	for i, record := range g.records {
+		if record.Info == "." || record.Info == "" {
+			continue
+		}
+		for field := range strings.SplitSeq(record.Info, ";") {
+			key, value, _ := strings.Cut(field, "=")
+			if key == "" {
+				continue
+			}
+			if _, exists := values[key]; !exists {
+				keys = append(keys, key)
+				values[key] = make([]string, len(g.records))
+				hasKey[key] = make([]bool, len(g.records))
+			}
+			if !hasKey[key][i] {
+				values[key][i] = value
+				hasKey[key][i] = true
+			}
+		}
+	}
+
+	fields := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if field, exists := rules.header.info(key); exists && field.Type == "Flag" {
+			fields = append(fields, key)
+			continue
+		}
+		value := g.mergeInfoValues(rules.infoRule(key), values[key], hasKey[key])
+		if value == "" {
+			fields = append(fields, key)
+		} else {
+			fields = append(fields, key+"="+value)
+		}
+	}
+
+	info := "."
+	if len(fields) > 0 {
+		info = strings.Join(fields, ";")
+	}
+
+	// AC, AN and AF are counted again if one of the records has them
+	countTags := slices.ContainsFunc(alleleCountTags, func(tag string) bool {
+		_, exists := values[tag]
+		_, overridden := rules.info[tag]
+		return exists && !overridden
+	})
+	if !countTags {
+		return info
+	}
+	var counts []int
+	var total int
+	if rules.alleleCounts == CountsRecompute && len(genotypes) > 0 {
+		counts, total = countAlleles(genotypes, len(g.alts))
+	} else {
+		counts, total = g.sumAlleleCounts(values["AC"], values["AN"])
+	}
+	info = setAlleleCountTags(info, counts, total)
+
+	// The tags missing from all records are not added, they may be not defined in the header
+	for _, tag := range alleleCountTags {
+		if _, exists := values[tag]; !exists {
+			info = setInfoValue(info, tag, "")
+		}
+	}
+	return info
+}
+
+// mergeInfoValues combines the values of one INFO key of the records
+func (g *alleleGroup) mergeInfoValues(rule string, values []string, hasKey []bool) string {
+	first := ""
+	for i, value := range values {
+		if hasKey[i] {
+			first = value
+			break
+		}
+	}
+
+	switch rule {
+	case "A", "R":
+		return g.mergeAlleleValues(values, hasKey, rule == "R")
+	case RuleJoin:
+		// The same values of several records are written once
+		var joined []string
+		for i, value := range values {
+			if hasKey[i] && value != "" && !slices.Contains(joined, value) {
+				joined = append(joined, value)
+			}
+		}
+		return strings.Join(joined, ",")
+	case RuleSum, RuleMin, RuleMax, RuleAvg:
+		result, sum := 0.0, 0.0
+		count := 0
+		for i, value := range values {
+			number, err := strconv.ParseFloat(value, 64)
+			if !hasKey[i] || err != nil {
+				continue
+			}
+			if count == 0 || rule == RuleMin && number < result || rule == RuleMax && number > result {
+				result = number
+			}
+			sum += number
+			count++
+		}
+		switch {
+		case count == 0:
+			return first
+		case rule == RuleSum:
+			result = sum
+		case rule == RuleAvg:
+			return formatAverage(sum, count)
+		}
+		return strconv.FormatFloat(result, 'f', -1, 64)
+	}
+	return first
+}
+
+// mergeAlleleValues combines the per-allele values (Number=A or Number=R): the value of each merged allele
+// is taken from the first record with the allele, missing values are "."
+func (g *alleleGroup) mergeAlleleValues(values []string, hasKey []bool, withRef bool) string {
+	offset := 1
+	if withRef {
+		offset = 0
+	}
+	merged := make([]string, len(g.alts)+1-offset)
+
+	for i, value := range values {
+		if !hasKey[i] {
+			continue
+		}
+		mapping := g.mappings[i]
+		for allele, item := range strings.Split(value, ",") {
+			allele += offset
+			if allele >= len(mapping) {
+				break
+			}
+			if index := mapping[allele] - offset; merged[index] == "" {
+				merged[index] = item
+			}
+		}
+	}
+
+	for i, item := range merged {
+		if item == "" {
+			merged[i] = "."
+		}
+	}
+	return strings.Join(merged, ",")
+}
+
+// sumAlleleCounts adds the AC of the records by merged allele and their AN
+func (g *alleleGroup) sumAlleleCounts(acValues, anValues []string) ([]int, int) {
+	counts := make([]int, len(g.alts))
+	total := 0
+	for i := range g.records {
+		if i < len(acValues) {
+			for allele, item := range strings.Split(acValues[i], ",") {
+				count, err := strconv.Atoi(item)
+				if err == nil && allele+1 < len(g.mappings[i]) {
+					counts[g.mappings[i][allele+1]-1] += count
+				}
+			}
+		}
+		if i < len(anValues) {
+			if count, err := strconv.Atoi(anValues[i]); err == nil {
+				total += count
+			}
+		}
+	}
+	return counts, total
+}
@@ -0,0 +1,83 @@
+package functions_go
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	progressMu       sync.RWMutex
+	progressCallback ProgressCallback
+)
+
+// SetProgressCallback registers a callback for progress updates, nil removes it
+func SetProgressCallback(callback ProgressCallback) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	progressCallback = callback
+}
+
+func getProgressCallback() ProgressCallback {
+	progressMu.RLock()
+	defer progressMu.RUnlock()
+	return progressCallback
+}
+
+// NewProgress creates a progress reporter for the operation.
+// total is the expected number of bytes to process or -1 if it is unknown.
+func NewProgress(operation string, total int64) *Progress {
+	return &Progress{
+		lastReport:  time.Now(),
+		operation:   operation,
+		total:       total,
+		minInterval: 200 * time.Millisecond,
+	}
+}
+
+// WithBar draws the terminal progress bar when no callback is registered
+func (p *Progress) WithBar(total int, options ...Option) *Progress {
+	if getProgressCallback() == nil {
+		p.bar = NewTqdm(total, options...)
+	}
+	return p
+}
+
+// Update adds processed records and sets the number of processed bytes
+func (p *Progress) Update(records int, bytes int64) {
+	p.records += int64(records)
+	if bytes > p.bytes {
+		p.bytes = bytes
+	}
+
+	if p.bar != nil {
+		p.bar.Update(records)
+	}
+
+	now := time.Now()
+	if now.Sub(p.lastReport) < p.minInterval {
+		return
+	}
+	p.lastReport = now
+	p.report()
+}
+
+// Increment adds one processed record
+func (p *Progress) Increment() {
+	p.Update(1, p.bytes)
+}
+
+// Close sends the final progress update
+func (p *Progress) Close() {
+	if p.bar != nil {
+		p.bar.Close()
+	}
+	p.report()
+}
+
+func (p *Progress) report() {
+	callback := getProgressCallback()
+	if callback == nil {
+		return
+	}
+	callback(p.operation, p.records, p.bytes, p.total)
+}
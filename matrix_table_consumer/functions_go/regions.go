@@ -0,0 +1,411 @@
+package functions_go
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ParseRegions parses a comma separated list of chr, chr:pos, chr:start-end or chr:start- (1-based, inclusive)
+func ParseRegions(regions string) ([]Region, error) {
+	var result []Region
+	for item := range strings.SplitSeq(regions, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		chrom, interval, hasInterval := strings.Cut(item, ":")
+		region := Region{Chrom: chrom, Start: 1, End: math.MaxInt64}
+		if hasInterval {
+			startValue, endValue, hasEnd := strings.Cut(interval, "-")
+			start, err := strconv.ParseInt(startValue, 10, 64)
+			if err != nil || start < 1 {
+				return nil, fmt.Errorf("invalid start of region '%s'", item)
+			}
+			region.Start = start
+			region.End = start
+			if hasEnd && endValue != "" {
+				end, err := strconv.ParseInt(endValue, 10, 64)
+				if err != nil || end < start {
+					return nil, fmt.Errorf("invalid end of region '%s'", item)
+				}
+				region.End = end
+			} else if hasEnd {
+				region.End = math.MaxInt64
+			}
+		}
+		if region.Chrom == "" {
+			return nil, fmt.Errorf("invalid region '%s'", item)
+		}
+		result = append(result, region)
+	}
+	return result, nil
+}
+
+// ReadBEDFile reads target intervals from a BED file (.bed or .bed.gz), 0-based starts are converted to 1-based
+func ReadBEDFile(bed_path string) ([]Region, error) {
+	reader, err := OpenVCF(bed_path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var regions []Region
+	scanner := GetScaner(reader.Reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+
+		columns := strings.Fields(line)
+		if len(columns) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected at least 3 columns", bed_path, lineNumber)
+		}
+		start, err := strconv.ParseInt(columns[1], 10, 64)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid start '%s'", bed_path, lineNumber, columns[1])
+		}
+		end, err := strconv.ParseInt(columns[2], 10, 64)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("%s:%d: invalid end '%s'", bed_path, lineNumber, columns[2])
+		}
+		if end == start {
+			continue
+		}
+		regions = append(regions, Region{Chrom: columns[0], Start: start + 1, End: end})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return regions, nil
+}
+
+// LoadRegions combines the regions string and the BED file, nil is returned if both are empty
+func LoadRegions(regions string, regions_file string) (*RegionSet, error) {
+	if regions == "" && regions_file == "" {
+		return nil, nil
+	}
+
+	list, err := ParseRegions(regions)
+	if err != nil {
+		return nil, err
+	}
+	if regions_file != "" {
+		bedRegions, err := ReadBEDFile(regions_file)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, bedRegions...)
+	}
+	set := NewRegionSet(list)
+	s := fmt.Sprintf("Regions: %s\n", set)
+	LoggerDebug(s)
+	return set, nil
+}
+
+func NewRegionSet(regions []Region) *RegionSet {
+	byContig := make(map[string][]Region)
+	set := &RegionSet{trees: make(map[string]*intervalTree)}
+	for _, region := range regions {
+		if _, exists := byContig[region.Chrom]; !exists {
+			set.contigs = append(set.contigs, region.Chrom)
+		}
+		byContig[region.Chrom] = append(byContig[region.Chrom], region)
+	}
+	for chrom, intervals := range byContig {
+		set.trees[chrom] = newIntervalTree(intervals)
+	}
+	return set
+}
+
+// Overlaps reports whether the interval [start, end] of the contig overlaps any region
+func (s *RegionSet) Overlaps(chrom string, start, end int64) bool {
+	tree, exists := s.trees[chrom]
+	if !exists {
+		return false
+	}
+	return tree.overlaps(0, len(tree.intervals), start, end)
+}
+
+// OverlapsRecord checks the interval from POS to END of the INFO column (or the end of REF) of a VCF line,
+// the same interval as in the tabix index
+func (s *RegionSet) OverlapsRecord(line string) bool {
+	parts := strings.SplitN(line, "\t", 9)
+	if len(parts) < 4 {
+		return false
+	}
+	pos, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false
+	}
+	end := pos + int64(max(len(parts[3]), 1)) - 1
+	if len(parts) > 7 {
+		if recordEnd, ok := infoEnd(parts[7]); ok {
+			end = int64(recordEnd)
+		}
+	}
+	return s.Overlaps(parts[0], pos, end)
+}
+
+// String returns the merged regions in the format of ParseRegions, contigs in the order of the input
+func (s *RegionSet) String() string {
+	var items []string
+	for _, chrom := range s.contigs {
+		for _, region := range s.merged(chrom) {
+			items = append(items, region.String())
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// String returns the region as chr, chr:start- or chr:start-end
+func (r Region) String() string {
+	switch {
+	case r.Start == 1 && r.End == math.MaxInt64:
+		return r.Chrom
+	case r.End == math.MaxInt64:
+		return fmt.Sprintf("%s:%d-", r.Chrom, r.Start)
+	}
+	return fmt.Sprintf("%s:%d-%d", r.Chrom, r.Start, r.End)
+}
+
+// merged returns sorted regions of the contig with the overlapping and adjacent ones joined
+func (s *RegionSet) merged(chrom string) []Region {
+	tree, exists := s.trees[chrom]
+	if !exists {
+		return nil
+	}
+
+	var regions []Region
+	for _, interval := range tree.intervals {
+		if last := len(regions) - 1; last >= 0 && interval.Start-1 <= regions[last].End {
+			regions[last].End = max(regions[last].End, interval.End)
+			continue
+		}
+		regions = append(regions, interval)
+	}
+	return regions
+}
+
+func newIntervalTree(intervals []Region) *intervalTree {
+	intervals = slices.Clone(intervals)
+	slices.SortFunc(intervals, func(a, b Region) int {
+		if a.Start != b.Start {
+			return compareInt64(a.Start, b.Start)
+		}
+		return compareInt64(a.End, b.End)
+	})
+
+	tree := &intervalTree{intervals: intervals, maxEnd: make([]int64, len(intervals))}
+	tree.build(0, len(intervals))
+	return tree
+}
+
+// build fills maxEnd of the subtree of [lo, hi), its root is the middle interval
+func (t *intervalTree) build(lo, hi int) int64 {
+	if lo >= hi {
+		return math.MinInt64
+	}
+	mid := (lo + hi) / 2
+	t.maxEnd[mid] = max(t.intervals[mid].End, t.build(lo, mid), t.build(mid+1, hi))
+	return t.maxEnd[mid]
+}
+
+func (t *intervalTree) overlaps(lo, hi int, start, end int64) bool {
+	if lo >= hi {
+		return false
+	}
+	mid := (lo + hi) / 2
+	if t.maxEnd[mid] < start {
+		return false
+	}
+	if t.overlaps(lo, mid, start, end) {
+		return true
+	}
+
+	interval := t.intervals[mid]
+	if interval.Start > end {
+		// The right subtree starts even further
+		return false
+	}
+	if interval.End >= start {
+		return true
+	}
+	return t.overlaps(mid+1, hi, start, end)
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// OpenVCFRegions opens a VCF file and returns the header and the records overlapping the regions.
+// The tabix index (vcf_path + ".tbi") is used for bgzip compressed files, other files are streamed
+// and the records are checked with an interval tree. All records are returned if regions is nil
+func OpenVCFRegions(vcf_path string, regions *RegionSet) (*RegionReader, error) {
+	indexPath := vcf_path + ".tbi"
+	if regions != nil && strings.HasSuffix(vcf_path, ".gz") {
+		if _, err := os.Stat(indexPath); err == nil {
+			if isBGZF(vcf_path) {
+				return openIndexedRegions(vcf_path, indexPath, regions)
+			}
+			s := fmt.Sprintf("%s is not compressed with bgzip, the index is not used\n", vcf_path)
+			LoggerWarning(s)
+		}
+	}
+
+	reader, err := OpenVCF(vcf_path)
+	if err != nil {
+		return nil, err
+	}
+	return &RegionReader{
+		reader:  reader,
+		scanner: GetScaner(reader.Reader),
+		regions: regions,
+		Size:    reader.Size,
+	}, nil
+}
+
+func openIndexedRegions(vcf_path string, index_path string, regions *RegionSet) (*RegionReader, error) {
+	index, err := loadTabixIndex(index_path)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []tabixChunk
+	for _, chrom := range regions.contigs {
+		for _, region := range regions.merged(chrom) {
+			chunks = append(chunks, index.query(region)...)
+		}
+	}
+
+	bgzf, err := openBGZF(vcf_path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := fmt.Sprintf("Using index %s\n", index_path)
+	LoggerDebug(s)
+
+	var size int64 = -1
+	if fileInfo, err := bgzf.file.Stat(); err == nil {
+		size = fileInfo.Size()
+	}
+	return &RegionReader{
+		bgzf:    bgzf,
+		chunks:  mergeChunks(chunks),
+		regions: regions,
+		Size:    size,
+	}, nil
+}
+
+// Scan moves to the next header line or record, false is returned at the end or on error
+func (r *RegionReader) Scan() bool {
+	if r.bgzf != nil {
+		return r.scanIndexed()
+	}
+
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if r.regions == nil || strings.HasPrefix(line, "#") || r.regions.OverlapsRecord(line) {
+			r.line = line
+			return true
+		}
+	}
+	r.err = r.scanner.Err()
+	return false
+}
+
+func (r *RegionReader) scanIndexed() bool {
+	if !r.headerDone {
+		line, err := r.bgzf.ReadLine()
+		if err == nil && strings.HasPrefix(line, "#") {
+			r.line = line
+			return true
+		}
+		if err != nil && err != io.EOF {
+			r.err = err
+			return false
+		}
+		r.headerDone = true
+
+		// The line after the header is the first record, it is read again from its chunk
+		if len(r.chunks) > 0 {
+			if err := r.bgzf.Seek(r.chunks[0].begin); err != nil {
+				r.err = err
+				return false
+			}
+		}
+	}
+
+	for r.chunk < len(r.chunks) {
+		chunk := r.chunks[r.chunk]
+		if !r.inChunk {
+			if offset := r.bgzf.Tell(); offset < chunk.begin || offset >= chunk.end {
+				if err := r.bgzf.Seek(chunk.begin); err != nil {
+					r.err = err
+					return false
+				}
+			}
+			r.inChunk = true
+		}
+
+		if r.bgzf.Tell() >= chunk.end {
+			r.chunk++
+			r.inChunk = false
+			continue
+		}
+
+		line, err := r.bgzf.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				r.err = err
+				return false
+			}
+			r.chunk++
+			r.inChunk = false
+			continue
+		}
+		if r.regions.OverlapsRecord(line) {
+			r.line = line
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RegionReader) Text() string {
+	return r.line
+}
+
+func (r *RegionReader) Err() error {
+	return r.err
+}
+
+// BytesRead returns the number of bytes read from the file on disk
+func (r *RegionReader) BytesRead() int64 {
+	if r.bgzf != nil {
+		return r.bgzf.bytesRead
+	}
+	return r.reader.BytesRead()
+}
+
+func (r *RegionReader) Close() error {
+	if r.bgzf != nil {
+		return r.bgzf.Close()
+	}
+	return r.reader.Close()
+}
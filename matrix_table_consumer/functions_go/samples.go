@@ -0,0 +1,254 @@
+package functions_go
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Header lines added when AC, AN or AF are recomputed and not defined in the input
+var recomputedTagLines = []struct {
+	id   string
+	line string
+}{
+	{"AC", `##INFO=<ID=AC,Number=A,Type=Integer,Description="Allele count in genotypes, for each ALT allele, in the same order as listed">`},
+	{"AN", `##INFO=<ID=AN,Number=1,Type=Integer,Description="Total number of alleles in called genotypes">`},
+	{"AF", `##INFO=<ID=AF,Number=A,Type=Float,Description="Allele frequency, for each ALT allele, in the same order as listed">`},
+}
+
+// newSampleSubset reads the samples to keep from the list or the file, nil is returned if the
+// samples are not changed. A leading ^ (of the list or of the file path) drops the samples instead
+func newSampleSubset(options FilterOptions) (*sampleSubset, error) {
+	if options.Samples == "" && options.SamplesFile == "" && !options.SitesOnly && !options.RecomputeTags {
+		return nil, nil
+	}
+	if options.Samples != "" && options.SamplesFile != "" {
+		return nil, fmt.Errorf("samples and samples file can not be used together")
+	}
+
+	subset := &sampleSubset{sitesOnly: options.SitesOnly, recompute: options.RecomputeTags}
+	if options.Samples != "" {
+		list, exclude := strings.CutPrefix(options.Samples, "^")
+		subset.exclude = exclude
+		for name := range strings.SplitSeq(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				subset.names = append(subset.names, name)
+			}
+		}
+	}
+
+	if options.SamplesFile != "" {
+		path, exclude := strings.CutPrefix(options.SamplesFile, "^")
+		subset.exclude = exclude
+
+		reader, err := OpenVCF(path)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		scanner := GetScaner(reader.Reader)
+		for scanner.Scan() {
+			if name := strings.TrimSpace(scanner.Text()); name != "" && !strings.HasPrefix(name, "#") {
+				subset.names = append(subset.names, name)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read samples file: %v", err)
+		}
+	}
+
+	if (options.Samples != "" || options.SamplesFile != "") && len(subset.names) == 0 && !subset.exclude {
+		return nil, fmt.Errorf("no samples to keep")
+	}
+	return subset, nil
+}
+
+// resolve finds the columns of the samples in the #CHROM line. Kept samples are written in the
+// order of the list, the other samples in the order of the file
+func (s *sampleSubset) resolve(line string) error {
+	if len(s.names) == 0 && !s.exclude {
+		return nil
+	}
+	if !strings.HasPrefix(line, "#CHROM") {
+		return fmt.Errorf("samples can not be selected without the #CHROM header line")
+	}
+
+	parts := strings.Split(line, "\t")
+	columns := make(map[string]int)
+	for i, name := range parts[min(len(parts), 9):] {
+		columns[name] = i
+	}
+
+	listed := make(map[string]bool)
+	for _, name := range s.names {
+		column, exists := columns[name]
+		if !exists {
+			return fmt.Errorf("sample %s is not in the header", name)
+		}
+		if !listed[name] && !s.exclude {
+			s.columns = append(s.columns, column)
+		}
+		listed[name] = true
+	}
+
+	if s.exclude {
+		s.columns = make([]int, 0, len(columns))
+		for i, name := range parts[min(len(parts), 9):] {
+			if !listed[name] {
+				s.columns = append(s.columns, i)
+			}
+		}
+	}
+	return nil
+}
+
+// headerLines returns the ##INFO lines of the recomputed tags missing from the header
+func (s *sampleSubset) headerLines(header *VCFHeader) []string {
+	if !s.recompute {
+		return nil
+	}
+
+	var lines []string
+	for _, tag := range recomputedTagLines {
+		if _, exists := header.Info[tag.id]; !exists {
+			lines = append(lines, tag.line)
+		}
+	}
+	return lines
+}
+
+// selectColumns keeps the FORMAT column and the selected samples of a #CHROM line or a record
+func (s *sampleSubset) selectColumns(parts []string) []string {
+	if s.columns == nil || len(parts) <= 9 {
+		return parts
+	}
+
+	selected := make([]string, 9, 9+len(s.columns))
+	copy(selected, parts[:9])
+	for _, column := range s.columns {
+		if 9+column < len(parts) {
+			selected = append(selected, parts[9+column])
+		}
+	}
+	return selected
+}
+
+// selectHeader returns the #CHROM line with the selected samples, the sample columns are
+// still present with SitesOnly, they are dropped by output
+func (s *sampleSubset) selectHeader(line string) string {
+	return strings.Join(s.selectColumns(strings.Split(line, "\t")), "\t")
+}
+
+// selectRecord keeps the selected samples of a record and recomputes AC, AN and AF
+func (s *sampleSubset) selectRecord(line string) string {
+	parts := s.selectColumns(strings.Split(line, "\t"))
+	if s.recompute && len(parts) >= 8 {
+		parts[7] = recomputeTags(parts)
+	}
+	return strings.Join(parts, "\t")
+}
+
+// output drops the FORMAT and sample columns of sites only VCF
+func (s *sampleSubset) output(line string) string {
+	if !s.sitesOnly {
+		return line
+	}
+	parts := strings.SplitN(line, "\t", 9)
+	return strings.Join(parts[:min(len(parts), 8)], "\t")
+}
+
+// recomputeTags returns the INFO column with AC, AN and AF counted from the GT of the samples
+func recomputeTags(parts []string) string {
+	altCount := len(splitAlts(parts[4]))
+
+	var genotypes []string
+	if len(parts) > 9 {
+		if gtIndex := slices.Index(strings.Split(parts[8], ":"), "GT"); gtIndex >= 0 {
+			for _, sample := range parts[9:] {
+				values := strings.Split(sample, ":")
+				if gtIndex < len(values) {
+					genotypes = append(genotypes, values[gtIndex])
+				}
+			}
+		}
+	}
+
+	counts, total := countAlleles(genotypes, altCount)
+	return setAlleleCountTags(parts[7], counts, total)
+}
+
+// countAlleles counts each ALT allele and all called alleles of the GT values
+func countAlleles(genotypes []string, altCount int) ([]int, int) {
+	counts := make([]int, altCount)
+	total := 0
+	for _, gt := range genotypes {
+		alleles, _ := parseGenotype(gt)
+		for _, allele := range alleles {
+			if allele < 0 {
+				continue
+			}
+			total++
+			if allele > 0 && allele <= altCount {
+				counts[allele-1]++
+			}
+		}
+	}
+	return counts, total
+}
+
+// setAlleleCountTags sets AC and AN of the INFO column and AF computed from them,
+// AC and AF are removed if there are no ALT alleles, AF is removed if no alleles are called
+func setAlleleCountTags(info string, counts []int, total int) string {
+	if len(counts) == 0 {
+		info = setInfoValue(info, "AC", "")
+		info = setInfoValue(info, "AN", strconv.Itoa(total))
+		return setInfoValue(info, "AF", "")
+	}
+
+	ac := make([]string, len(counts))
+	af := make([]string, len(counts))
+	for i, count := range counts {
+		ac[i] = strconv.Itoa(count)
+		if total > 0 {
+			af[i] = strconv.FormatFloat(float64(count)/float64(total), 'g', 6, 64)
+		}
+	}
+	info = setInfoValue(info, "AC", strings.Join(ac, ","))
+	info = setInfoValue(info, "AN", strconv.Itoa(total))
+	if total == 0 {
+		return setInfoValue(info, "AF", "")
+	}
+	return setInfoValue(info, "AF", strings.Join(af, ","))
+}
+
+// setInfoValue replaces the value of an INFO key or appends it, an empty value removes the key
+func setInfoValue(info string, key string, value string) string {
+	var fields []string
+	if info != "." && info != "" {
+		fields = strings.Split(info, ";")
+	}
+
+	found := false
+	result := make([]string, 0, len(fields)+1)
+	for _, field := range fields {
+		name, _, _ := strings.Cut(field, "=")
+		if name != key {
+			result = append(result, field)
+			continue
+		}
+		if value != "" && !found {
+			result = append(result, key+"="+value)
+		}
+		found = true
+	}
+	if !found && value != "" {
+		result = append(result, key+"="+value)
+	}
+
+	if len(result) == 0 {
+		return "."
+	}
+	return strings.Join(result, ";")
+}
@@ -2,30 +2,27 @@ package functions_go
 
 import (
 	"bufio"
+	"cmp"
 	"compress/gzip"
+	"container/heap"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
-// Logger functions (replace with your actual logger implementation)
-func loggerInfo(format string, args ...interface{}) {
-	log.Printf("[INFO] "+format, args...)
-}
-
-func loggerError(format string, args ...interface{}) {
-	log.Printf("[ERROR] "+format, args...)
-}
-
 type VCFRecord struct {
 	Chromosome string
 	Position   int
 	Line       string
+	contig     contigKey
+	ref        string
+	alt        string
+	end        int
 }
 
 type ByChromosomePos []VCFRecord
@@ -33,248 +30,372 @@ type ByChromosomePos []VCFRecord
 func (a ByChromosomePos) Len() int      { return len(a) }
 func (a ByChromosomePos) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 func (a ByChromosomePos) Less(i, j int) bool {
-	keyI := chromosomeKey(a[i].Chromosome)
-	keyJ := chromosomeKey(a[j].Chromosome)
+	return compareRecords(&a[i], &a[j]) < 0
+}
 
-	if keyI != keyJ {
-		return keyI < keyJ
+// compareRecords orders the records by contig and position, the records at the same position
+// by REF, ALT and END, so that the order does not depend on the input. END is 0 if it is not parsed
+func compareRecords(a, b *VCFRecord) int {
+	if order := comparePositions(a, b); order != 0 {
+		return order
+	}
+	if a.ref != b.ref {
+		return strings.Compare(a.ref, b.ref)
 	}
-	return a[i].Position < a[j].Position
+	if a.alt != b.alt {
+		return strings.Compare(a.alt, b.alt)
+	}
+	return cmp.Compare(a.end, b.end)
 }
 
-func chromosomeKey(chrom string) int {
-	chrom = strings.ToUpper(chrom)
-	if strings.HasPrefix(chrom, "CHR") {
-		chrom = chrom[3:]
+// comparePositions orders the records by contig and position only
+func comparePositions(a, b *VCFRecord) int {
+	if order := compareContigKeys(a.contig, b.contig); order != 0 {
+		return order
 	}
+	return cmp.Compare(a.Position, b.Position)
+}
+
+// sortMergeHeap is a min-heap of the current records of the merged files
+type sortMergeHeap []*sortMergeSource
 
-	// Try to parse as integer
-	if num, err := strconv.Atoi(chrom); err == nil {
-		return num
+func (h sortMergeHeap) Len() int      { return len(h) }
+func (h sortMergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h sortMergeHeap) Less(i, j int) bool {
+	// Equal records are written in the order of the files, which is the order of the input
+	if order := compareRecords(&h[i].record, &h[j].record); order != 0 {
+		return order < 0
 	}
+	return h[i].index < h[j].index
+}
+func (h *sortMergeHeap) Push(x any) { *h = append(*h, x.(*sortMergeSource)) }
+func (h *sortMergeHeap) Pop() any {
+	old := *h
+	source := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return source
+}
 
-	// Special chromosomes
-	specialChroms := map[string]int{
-		"X":  100,
-		"Y":  101,
-		"MT": 102,
-		"M":  102,
+// DefaultSortMemory is the memory budget of Sort if it is not set
+const DefaultSortMemory int64 = 1 << 30
+
+// DefaultSortMaxOpenFiles is the number of temporary files Sort merges at once by default,
+// below the usual open file limit of 1024
+const DefaultSortMaxOpenFiles = 128
+
+// sortRecordOverhead is the estimated memory of a VCFRecord without the line
+const sortRecordOverhead = 128
+
+// parseRecord parses the sort key of a line, header and malformed lines are skipped.
+// END is parsed only if byEnd is set, it needs a scan of the INFO column of every record
+func parseRecord(line string, order *ContigOrder, byEnd bool) (VCFRecord, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return VCFRecord{}, false
 	}
 
-	if val, exists := specialChroms[chrom]; exists {
-		return val
+	line += "\n"
+	parts := strings.SplitN(line, "\t", 9)
+	if len(parts) < 2 {
+		return VCFRecord{}, false
+	}
+	pos, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return VCFRecord{}, false
 	}
 
-	// Default for other chromosomes
-	return 1000 + int(hashString(chrom))
+	record := VCFRecord{
+		Chromosome: parts[0],
+		Position:   pos,
+		Line:       line,
+		contig:     order.Key(parts[0]),
+	}
+	if len(parts) >= 5 {
+		record.ref = strings.TrimSpace(parts[3])
+		record.alt = strings.TrimSpace(parts[4])
+	}
+	if !byEnd {
+		return record, true
+	}
+	record.end = pos + max(len(record.ref), 1) - 1
+	if len(parts) >= 8 {
+		if end, ok := infoEnd(strings.TrimSpace(parts[7])); ok {
+			record.end = end
+		}
+	}
+	return record, true
 }
 
-func hashString(s string) uint32 {
-	var h uint32 = 0
-	for _, char := range s {
-		h = h*31 + uint32(char)
+// infoEnd returns the END of the INFO column
+func infoEnd(info string) (int, bool) {
+	for field := range strings.SplitSeq(info, ";") {
+		if value, found := strings.CutPrefix(field, "END="); found {
+			end, err := strconv.Atoi(value)
+			return end, err == nil
+		}
 	}
-	return h
+	return 0, false
 }
 
-func readChunk(reader *bufio.Reader, size int) ([]VCFRecord, error) {
-	chunk := make([]VCFRecord, 0, size)
+// readChunk reads records until the chunk has maxLines records (0 - no limit) or the estimated memory
+// of the records reaches maxBytes. done is true at the end of the input
+func readChunk(reader *bufio.Reader, maxLines int, maxBytes int64, order *ContigOrder, byEnd bool) ([]VCFRecord, bool, error) {
+	var chunk []VCFRecord
+	var size int64 = 0
 
-	for range size {
+	for (maxLines <= 0 || len(chunk) < maxLines) && size < maxBytes {
 		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
+		if err != nil && err != io.EOF {
+			return nil, false, err
 		}
+		done := err == io.EOF
 
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+		if record, ok := parseRecord(line, order, byEnd); ok {
+			chunk = append(chunk, record)
+			size += int64(len(record.Line)) + sortRecordOverhead
 		}
 
-		parts := strings.Split(line, "\t")
-		if len(parts) < 2 {
-			continue
+		if done {
+			return chunk, true, nil
 		}
+	}
 
-		pos, err := strconv.Atoi(parts[1])
-		if err != nil {
-			continue
-		}
+	return chunk, false, nil
+}
 
-		chunk = append(chunk, VCFRecord{
-			Chromosome: parts[0],
-			Position:   pos,
-			Line:       line + "\n",
-		})
+// writeRecords writes the lines of the records
+func writeRecords(writer io.Writer, records []VCFRecord) error {
+	buffered := bufio.NewWriter(writer)
+	for _, record := range records {
+		if _, err := buffered.WriteString(record.Line); err != nil {
+			return err
+		}
 	}
-
-	return chunk, nil
+	return buffered.Flush()
 }
 
-func writeChunk(chunk []VCFRecord, filename string) error {
+// writeChunk writes the records to a temporary file, compressed with gzip if level is greater than 0
+func writeChunk(chunk []VCFRecord, filename string, level int) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	gzWriter := gzip.NewWriter(file)
-	defer gzWriter.Close()
-
-	writer := bufio.NewWriter(gzWriter)
-	defer writer.Flush()
-
-	for _, record := range chunk {
-		_, err := writer.WriteString(record.Line)
-		if err != nil {
+	if level <= 0 {
+		if err := writeRecords(file, chunk); err != nil {
 			return err
 		}
+		return file.Close()
+	}
+
+	gzWriter, err := gzip.NewWriterLevel(file, level)
+	if err != nil {
+		return err
+	}
+	if err := writeRecords(gzWriter, chunk); err != nil {
+		return err
+	}
+	if err := gzWriter.Close(); err != nil {
+		return err
 	}
+	return file.Close()
+}
 
-	return nil
+// openChunk opens a temporary file written by writeChunk
+func openChunk(file *os.File, compressed bool) (*bufio.Reader, error) {
+	if !compressed {
+		return bufio.NewReader(file), nil
+	}
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(gzReader), nil
+}
+
+// next reads the next record of the file, false is returned at the end of the file
+func (source *sortMergeSource) next(order *ContigOrder, byEnd bool) (bool, error) {
+	for {
+		line, err := source.reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if record, ok := parseRecord(line, order, byEnd); ok {
+			source.record = record
+			return true, nil
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+	}
 }
 
-func mergeSortedFiles(filePaths []string, outputFile string) error {
-	// Open all files
-	files := make([]*os.File, len(filePaths))
-	readers := make([]*bufio.Reader, len(filePaths))
-	currentLines := make([]string, len(filePaths))
+// mergeSortedFiles merges the sorted temporary files into the writer with a heap of their current records
+func mergeSortedFiles(filePaths []string, writer io.Writer, order *ContigOrder, byEnd bool, compressed bool) error {
+	files := make([]*os.File, 0, len(filePaths))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
 
-	for i, path := range filePaths {
+	sources := make(sortMergeHeap, 0, len(filePaths))
+	for _, path := range filePaths {
 		file, err := os.Open(path)
 		if err != nil {
 			return err
 		}
-		files[i] = file
+		files = append(files, file)
 
-		gzReader, err := gzip.NewReader(file)
+		reader, err := openChunk(file, compressed)
 		if err != nil {
 			return err
 		}
-		inputFile := gzReader
-		readers[i] = bufio.NewReader(inputFile)
-
-		line, err := readers[i].ReadString('\n')
-		if err != nil && err != io.EOF {
+		source := &sortMergeSource{reader: reader, index: len(files) - 1}
+		found, err := source.next(order, byEnd)
+		if err != nil {
 			return err
 		}
-		currentLines[i] = line
-	}
-	defer func() {
-		for _, file := range files {
-			file.Close()
+		if found {
+			sources = append(sources, source)
 		}
-	}()
-
-	// Create output file
-	outFile, err := os.Create(outputFile)
-	if err != nil {
-		return err
 	}
-	defer outFile.Close()
+	heap.Init(&sources)
 
-	writer := bufio.NewWriter(outFile)
-	defer writer.Flush()
+	buffered := bufio.NewWriter(writer)
+	for len(sources) > 0 {
+		source := sources[0]
+		if _, err := buffered.WriteString(source.record.Line); err != nil {
+			return err
+		}
 
-	for {
-		// Find the minimum record
-		minIndex := -1
-		var minRecord string
-		var minKey struct {
-			chromKey int
-			position int
+		found, err := source.next(order, byEnd)
+		if err != nil {
+			return err
+		}
+		if found {
+			heap.Fix(&sources, 0)
+		} else {
+			heap.Pop(&sources)
 		}
+	}
+	return buffered.Flush()
+}
 
-		for i, line := range currentLines {
-			if line == "" {
-				continue
+// mergeSortedChunks merges the temporary files into the writer. If there are more files than maxOpenFiles,
+// they are merged in groups into larger temporary files first, so that the open files stay below the limit
+func mergeSortedChunks(filePaths []string, writer io.Writer, order *ContigOrder, byEnd bool, tempDir string, level int, maxOpenFiles int) error {
+	for round := 0; len(filePaths) > maxOpenFiles; round++ {
+		var merged []string
+		for i := 0; i < len(filePaths); i += maxOpenFiles {
+			group := filePaths[i:min(i+maxOpenFiles, len(filePaths))]
+			path := filepath.Join(tempDir, fmt.Sprintf("merge_%d_%06d.tmp", round, len(merged)))
+			if err := mergeToChunk(group, path, order, byEnd, level); err != nil {
+				return err
 			}
-
-			parts := strings.Split(strings.TrimSpace(line), "\t")
-			if len(parts) < 2 {
-				continue
+			for _, groupPath := range group {
+				os.Remove(groupPath)
 			}
+			merged = append(merged, path)
+		}
 
-			pos, err := strconv.Atoi(parts[1])
-			if err != nil {
-				continue
-			}
+		s := fmt.Sprintf("Merged %d chunks into %d\n", len(filePaths), len(merged))
+		LoggerDebug(s)
+		filePaths = merged
+	}
 
-			chromKey := chromosomeKey(parts[0])
-			currentKey := struct {
-				chromKey int
-				position int
-			}{chromKey, pos}
-
-			if minIndex == -1 || currentKey.chromKey < minKey.chromKey ||
-				(currentKey.chromKey == minKey.chromKey && currentKey.position < minKey.position) {
-				minIndex = i
-				minRecord = line
-				minKey = currentKey
-			}
-		}
+	return mergeSortedFiles(filePaths, writer, order, byEnd, level > 0)
+}
 
-		if minIndex == -1 {
-			break
-		}
+// mergeToChunk merges the temporary files into a new temporary file
+func mergeToChunk(filePaths []string, path string, order *ContigOrder, byEnd bool, level int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-		// Write the minimum record
-		_, err := writer.WriteString(minRecord)
-		if err != nil {
+	if level <= 0 {
+		if err := mergeSortedFiles(filePaths, file, order, byEnd, false); err != nil {
 			return err
 		}
-
-		// Read next line from the file that had the minimum record
-		line, err := readers[minIndex].ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				currentLines[minIndex] = ""
-			} else {
-				return err
-			}
-		} else {
-			currentLines[minIndex] = line
-		}
+		return file.Close()
 	}
 
-	return nil
+	gzWriter, err := gzip.NewWriterLevel(file, level)
+	if err != nil {
+		return err
+	}
+	if err := mergeSortedFiles(filePaths, gzWriter, order, byEnd, true); err != nil {
+		return err
+	}
+	if err := gzWriter.Close(); err != nil {
+		return err
+	}
+	return file.Close()
 }
 
-func Sort(inputVCF, outputVCF string, chunkSize int) {
-	tempDir, err := os.MkdirTemp("", "vcf_sort_")
+// Sort sorts a VCF file by contig and position. The contig order is taken from the .fai or .dict file of
+// options.Reference, or from the ##contig header lines. Contigs that are not listed follow in the natural order:
+// numbered contigs (chr prefix is ignored), X, Y, MT and the other contigs by name
+// Records at the same position are ordered by REF and ALT, and by END if options.ByEnd is set,
+// equal records keep the order of the input
+func Sort(options SortOptions) {
+	inputVCF, outputVCF := options.InputVCF, options.OutputVCF
+	numCPU := max(options.NumCPU, 1)
+	memoryBudget := options.MemoryBudget
+	if memoryBudget <= 0 {
+		memoryBudget = DefaultSortMemory
+	}
+	if options.MaxOpenFiles == 0 {
+		options.MaxOpenFiles = DefaultSortMaxOpenFiles
+	}
+	if options.MaxOpenFiles < 2 {
+		s := fmt.Sprintf("Invalid limit of open files %d, at least 2 files are merged at once\n", options.MaxOpenFiles)
+		LoggerError(s)
+		return
+	}
+	if options.TempCompression < 0 || options.TempCompression > 9 {
+		s := fmt.Sprintf("Invalid temp compression level %d, expected 0-9\n", options.TempCompression)
+		LoggerError(s)
+		return
+	}
+	// Each worker sorts one chunk while the next chunk is read
+	chunkBytes := max(memoryBudget/int64(numCPU+1), 1)
+
+	order := NewContigOrder()
+	if options.Reference != "" {
+		var err error
+		if order, err = LoadContigOrder(options.Reference); err != nil {
+			s := fmt.Sprintf("Error reading contig order: %v\n", err)
+			LoggerError(s)
+			return
+		}
+	}
+
+	tempDir, err := os.MkdirTemp(options.TempDir, "vcf_sort_")
 	if err != nil {
-		loggerError("Error creating temp directory: %v", err)
+		s := fmt.Sprintf("Error creating temp directory: %v\n", err)
+		LoggerError(s)
 		return
 	}
 	defer os.RemoveAll(tempDir)
 
-	loggerInfo("Temp dir: %s", tempDir)
+	s := fmt.Sprintf("Temp dir: %s\n", tempDir)
+	LoggerInfo(s)
 	tempFiles := []string{}
 
 	// Open input file
-	var inputFile io.ReadCloser
-	inputFile, err = os.Open(inputVCF)
+	inputFile, err := OpenVCF(inputVCF)
 	if err != nil {
-		loggerError("Error opening input file: %v", err)
+		s := fmt.Sprintf("Error opening input file: %v\n", err)
+		LoggerError(s)
 		return
 	}
 	defer inputFile.Close()
 
-	// Handle gzip compression
-	if strings.HasSuffix(inputVCF, ".gz") {
-		gzReader, err := gzip.NewReader(inputFile)
-		if err != nil {
-			loggerError("Error creating gzip reader: %v", err)
-			return
-		}
-		defer gzReader.Close()
-		inputFile = gzReader
-	}
-
-	reader := bufio.NewReader(inputFile)
+	reader := inputFile.Reader
 
 	// Read headers
 	headerLines := []string{}
@@ -284,12 +405,14 @@ func Sort(inputVCF, outputVCF string, chunkSize int) {
 			if err == io.EOF {
 				break
 			}
-			loggerError("Error reading headers: %v", err)
+			s := fmt.Sprintf("Error reading headers: %v\n", err)
+			LoggerError(s)
 			return
 		}
 
 		if strings.HasPrefix(line, "#") {
 			headerLines = append(headerLines, line)
+			order.ParseHeaderLine(strings.TrimSpace(line))
 		} else {
 			// Put the line back for data processing
 			reader = bufio.NewReader(io.MultiReader(
@@ -300,112 +423,115 @@ func Sort(inputVCF, outputVCF string, chunkSize int) {
 		}
 	}
 
-	// Process file in chunks
-	chunkCount := 0
+	if order.Len() == 0 {
+		LoggerInfo("No ##contig lines, contigs are sorted in the natural order\n")
+	}
+
+	// The chunks are sorted and written to the temp files by the workers
+	chunks := make(chan sortChunk)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var spillErr error
+	for range numCPU {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				sort.Stable(ByChromosomePos(chunk.records))
+				if err := writeChunk(chunk.records, chunk.path, options.TempCompression); err != nil {
+					mu.Lock()
+					if spillErr == nil {
+						spillErr = err
+					}
+					mu.Unlock()
+					continue
+				}
+
+				size, err := GetFileSizeMB(chunk.path)
+				if err != nil {
+					s := fmt.Sprintf("Error getting file size: %v\n", err)
+					LoggerError(s)
+				}
+				s := fmt.Sprintf("Saved chunk in %s %f Mb\n", chunk.path, size)
+				LoggerDebug(s)
+			}
+		}()
+	}
+
+	// Process file in chunks, the input that fits in one chunk is sorted in memory
+	progress := NewProgress("sort", inputFile.Size)
+	var inMemory []VCFRecord
 	for {
-		chunk, err := readChunk(reader, chunkSize)
+		records, done, err := readChunk(reader, options.ChunkSize, chunkBytes, order, options.ByEnd)
 		if err != nil {
-			loggerError("Error reading chunk: %v", err)
+			close(chunks)
+			wg.Wait()
+			s := fmt.Sprintf("Error reading chunk: %v\n", err)
+			LoggerError(s)
 			return
 		}
 
-		if len(chunk) == 0 {
-			break
+		if len(records) > 0 {
+			progress.Update(len(records), inputFile.BytesRead())
+			if done && len(tempFiles) == 0 {
+				sort.Stable(ByChromosomePos(records))
+				inMemory = records
+			} else {
+				tempFile := filepath.Join(tempDir, fmt.Sprintf("chunk_%06d.tmp", len(tempFiles)))
+				tempFiles = append(tempFiles, tempFile)
+				chunks <- sortChunk{records: records, path: tempFile}
+			}
 		}
-
-		// Sort chunk
-		sort.Sort(ByChromosomePos(chunk))
-
-		// Write sorted chunk to temp file
-		tempFile := filepath.Join(tempDir, fmt.Sprintf("chunk_%d.tmp", chunkCount))
-		err = writeChunk(chunk, tempFile)
-		if err != nil {
-			loggerError("Error writing chunk: %v", err)
-			return
+		if done {
+			break
 		}
+	}
+	close(chunks)
+	wg.Wait()
+	progress.Close()
 
-		size, err := GetFileSizeMB(tempFile)
-		if err != nil {
-			loggerError("Error getting file size: %v", err)
-
-		}
-		loggerInfo("Saved chunk in %s %f Mb", tempFile, size)
-		tempFiles = append(tempFiles, tempFile)
-		chunkCount++
+	if spillErr != nil {
+		s := fmt.Sprintf("Error writing chunk: %v\n", spillErr)
+		LoggerError(s)
+		return
 	}
 
 	// Create output file
 	outputFile, err := os.Create(outputVCF)
 	if err != nil {
-		loggerError("Error creating output file: %v", err)
+		s := fmt.Sprintf("Error creating output file: %v\n", err)
+		LoggerError(s)
 		return
 	}
 	defer outputFile.Close()
 
 	writer := bufio.NewWriter(outputFile)
-	defer writer.Flush()
 
 	// Write headers
 	for _, header := range headerLines {
 		_, err := writer.WriteString(header)
 		if err != nil {
-			loggerError("Error writing headers: %v", err)
+			s := fmt.Sprintf("Error writing headers: %v\n", err)
+			LoggerError(s)
 			return
 		}
 	}
 
-	// Handle single chunk case
-	if len(tempFiles) == 1 {
-		tempFile, err := os.Open(tempFiles[0])
-		if err != nil {
-			loggerError("Error opening temp file: %v", err)
-			return
-		}
-		defer tempFile.Close()
-
-		gzReader, err := gzip.NewReader(tempFile)
-		if err != nil {
-			loggerError("Error creating gzip reader: %v", err)
-			return
-		}
-		defer gzReader.Close()
-
-		_, err = io.Copy(writer, gzReader)
-		if err != nil {
-			loggerError("Error copying temp file: %v", err)
-			return
-		}
+	if len(tempFiles) == 0 {
+		err = writeRecords(writer, inMemory)
 	} else {
-		// Merge multiple chunks
-		mergedTemp := filepath.Join(tempDir, "merged.tmp")
-		err = mergeSortedFiles(tempFiles, mergedTemp)
-		if err != nil {
-			loggerError("Error merging files: %v", err)
-			return
-		}
-		defer os.Remove(mergedTemp)
-
-		// Copy merged data to output
-		mergedFile, err := os.Open(mergedTemp)
-		if err != nil {
-			loggerError("Error opening merged file: %v", err)
-			return
-		}
-		defer mergedFile.Close()
-
-		gzReader, err := gzip.NewReader(mergedFile)
-		if err != nil {
-			loggerError("Error creating gzip reader: %v", err)
-			return
-		}
-		defer gzReader.Close()
-
-		_, err = io.Copy(writer, gzReader)
-		if err != nil {
-			loggerError("Error copying merged file: %v", err)
-			return
-		}
+		// Merge the sorted chunks straight into the output
+		err = mergeSortedChunks(tempFiles, writer, order, options.ByEnd, tempDir, options.TempCompression, options.MaxOpenFiles)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		s := fmt.Sprintf("Error writing output file: %v\n", err)
+		LoggerError(s)
+		return
 	}
 
-	loggerInfo("Successfully sorted %d chunks", chunkCount)
+	s = fmt.Sprintf("Successfully sorted %d chunks\n", max(len(tempFiles), 1))
+	LoggerInfo(s)
 }
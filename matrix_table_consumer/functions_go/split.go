@@ -0,0 +1,378 @@
+package functions_go
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// splitFileName replaces characters that can not be used in file names, a suffix is added
+// if the name is already used (contigs such as HLA-A*01:01 and HLA-A_01_01)
+func splitFileName(name string, used map[string]bool) string {
+	base := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	if base == "" || base == "." || base == ".." {
+		base = "_" + base
+	}
+
+	fileName := base
+	for i := 2; used[fileName]; i++ {
+		fileName = fmt.Sprintf("%s_%d", base, i)
+	}
+	used[fileName] = true
+	return fileName
+}
+
+// DefaultSplitMaxOpenFiles is the number of output files Split keeps open at once by default,
+// below the usual open file limit of 1024
+const DefaultSplitMaxOpenFiles = 256
+
+// open opens the output file, the header is written to a new file. The records of a file
+// closed to stay under the open file limit are appended (resume)
+func (o *splitOutput) open(compress bool, header []string, resume bool) error {
+	if compress {
+		var bgzf *bgzfWriter
+		var err error
+		if resume {
+			bgzf, err = appendBGZF(o.path)
+		} else {
+			bgzf, err = createBGZF(o.path)
+		}
+		if err != nil {
+			return err
+		}
+		o.bgzf = bgzf
+		o.writer = bufio.NewWriter(bgzf)
+	} else {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if resume {
+			flags = os.O_WRONLY | os.O_APPEND
+		}
+		file, err := os.OpenFile(o.path, flags, 0o644)
+		if err != nil {
+			return err
+		}
+		o.file = file
+		o.writer = bufio.NewWriter(file)
+	}
+
+	if resume {
+		return nil
+	}
+	for _, line := range header {
+		if _, err := fmt.Fprintln(o.writer, line); err != nil {
+			o.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *splitOutput) Close() error {
+	if o.writer == nil {
+		return nil
+	}
+	err := o.writer.Flush()
+	o.writer = nil
+	if o.bgzf != nil {
+		if closeErr := o.bgzf.Close(); err == nil {
+			err = closeErr
+		}
+		o.bgzf = nil
+		return err
+	}
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	o.file = nil
+	return err
+}
+
+func splitManifestJSON(manifest *SplitManifest) string {
+	if manifest.Files == nil {
+		manifest.Files = make([]SplitFile, 0)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		s := fmt.Sprintf("JSON conversion error: %v\n", err)
+		LoggerError(s)
+		return "{}"
+	}
+	return string(data)
+}
+
+// Split writes one VCF per contig (or one single-sample VCF per sample) and returns the manifest
+// of the written files in JSON format. At most MaxOpenFiles outputs are open at once, by sample
+// the input is read once per batch of MaxOpenFiles samples.
+// All outputs get the header of the input, by sample the #CHROM line has only the sample column
+func Split(options SplitOptions) string {
+	manifest := &SplitManifest{}
+	fail := func(s string) string {
+		LoggerError(s)
+		manifest.Error = strings.TrimSpace(s)
+		return splitManifestJSON(manifest)
+	}
+
+	if options.MaxOpenFiles == 0 {
+		options.MaxOpenFiles = DefaultSplitMaxOpenFiles
+	}
+	if options.MaxOpenFiles < 1 {
+		return fail(fmt.Sprintf("Error splitting: the limit of open files must be positive, got %d\n", options.MaxOpenFiles))
+	}
+
+	if _, err := os.Stat(options.InputVCF); err != nil {
+		return fail(fmt.Sprintf("Error opening input file: %v\n", err))
+	}
+	if err := os.MkdirAll(options.OutputDir, 0o755); err != nil {
+		return fail(fmt.Sprintf("Error creating output directory: %v\n", err))
+	}
+
+	var err error
+	if options.BySample {
+		err = splitBySample(options, manifest)
+	} else {
+		err = splitByContig(options, manifest)
+	}
+	if err != nil {
+		return fail(fmt.Sprintf("%v\n", err))
+	}
+
+	s := fmt.Sprintf("Split %s into %d files\n", options.InputVCF, len(manifest.Files))
+	LoggerInfo(s)
+	return splitManifestJSON(manifest)
+}
+
+// splitPath returns the path of the output of a contig or a sample
+func splitPath(options SplitOptions, name string, usedNames map[string]bool) string {
+	extension := ".vcf"
+	if options.BGZF {
+		extension = ".vcf.gz"
+	}
+	return filepath.Join(options.OutputDir, splitFileName(name, usedNames)+extension)
+}
+
+// splitByContig writes the records of each contig to its output. When MaxOpenFiles outputs are open,
+// the least recently used one is closed, for sorted inputs it is the output of a finished contig
+func splitByContig(options SplitOptions, manifest *SplitManifest) error {
+	reader, err := OpenVCF(options.InputVCF)
+	if err != nil {
+		return fmt.Errorf("Error opening input file: %v", err)
+	}
+	defer reader.Close()
+
+	var header []string
+	contigs := make(map[string]*splitOutput)
+	usedNames := make(map[string]bool)
+	// open holds the open outputs, the least recently used first
+	var open []*splitOutput
+	defer func() {
+		for _, output := range open {
+			output.Close()
+		}
+	}()
+
+	// use returns the open output of the contig, the output is created or opened again if needed
+	use := func(contig string) (*splitOutput, error) {
+		output, exists := contigs[contig]
+		if exists && output.writer != nil {
+			open = slices.DeleteFunc(open, func(o *splitOutput) bool { return o == output })
+			open = append(open, output)
+			return output, nil
+		}
+
+		if len(open) >= options.MaxOpenFiles {
+			if err := open[0].Close(); err != nil {
+				return nil, fmt.Errorf("Error writing output file: %v", err)
+			}
+			open = open[1:]
+		}
+
+		if !exists {
+			output = &splitOutput{path: splitPath(options, contig, usedNames), index: len(manifest.Files)}
+			manifest.Files = append(manifest.Files, SplitFile{Name: contig, Path: output.path})
+			contigs[contig] = output
+
+			s := fmt.Sprintf("Writing %s to %s\n", contig, output.path)
+			LoggerDebug(s)
+		}
+		if err := output.open(options.BGZF, header, exists); err != nil {
+			return nil, fmt.Errorf("Error creating output file: %v", err)
+		}
+		open = append(open, output)
+		return output, nil
+	}
+
+	progress := NewProgress("split", reader.Size)
+	scanner := GetScaner(reader.Reader)
+	var output *splitOutput
+	contig := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			header = append(header, line)
+			continue
+		}
+
+		parts := strings.SplitN(line, "\t", 9)
+		if len(parts) < 8 {
+			continue
+		}
+		progress.Update(1, reader.BytesRead())
+
+		if output == nil || parts[0] != contig {
+			contig = parts[0]
+			if output, err = use(contig); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(output.writer, line)
+		manifest.Files[output.index].Records++
+	}
+	progress.Close()
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error reading input: %v", err)
+	}
+
+	for len(open) > 0 {
+		output, rest := open[0], open[1:]
+		open = rest
+		if err := output.Close(); err != nil {
+			return fmt.Errorf("Error writing output file: %v", err)
+		}
+	}
+	return nil
+}
+
+// splitBySample reads the header and writes the samples in batches of MaxOpenFiles, one pass over the input per batch
+func splitBySample(options SplitOptions, manifest *SplitManifest) error {
+	reader, err := OpenVCF(options.InputVCF)
+	if err != nil {
+		return fmt.Errorf("Error opening input file: %v", err)
+	}
+	var header []string
+	scanner := GetScaner(reader.Reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		header = append(header, line)
+		if strings.HasPrefix(line, "#CHROM") {
+			break
+		}
+	}
+	err = scanner.Err()
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("Error reading input: %v", err)
+	}
+
+	if len(header) == 0 || !strings.HasPrefix(header[len(header)-1], "#CHROM") {
+		return fmt.Errorf("Error splitting by sample: %s has no #CHROM line", options.InputVCF)
+	}
+	columns := strings.Split(header[len(header)-1], "\t")
+	if len(columns) <= 9 {
+		return fmt.Errorf("Error splitting by sample: %s has no samples", options.InputVCF)
+	}
+
+	usedNames := make(map[string]bool)
+	samples := make([]*splitOutput, len(columns)-9)
+	for i, sample := range columns[9:] {
+		samples[i] = &splitOutput{path: splitPath(options, sample, usedNames), index: len(manifest.Files)}
+		manifest.Files = append(manifest.Files, SplitFile{Name: sample, Path: samples[i].path})
+	}
+
+	if len(samples) > options.MaxOpenFiles {
+		passes := (len(samples) + options.MaxOpenFiles - 1) / options.MaxOpenFiles
+		s := fmt.Sprintf("Splitting %d samples in %d passes of %d samples (the limit of open files)\n", len(samples), passes, options.MaxOpenFiles)
+		LoggerInfo(s)
+	}
+
+	fixedColumns := strings.Join(columns[:9], "\t")
+	for first := 0; first < len(samples); first += options.MaxOpenFiles {
+		last := min(first+options.MaxOpenFiles, len(samples))
+		if err := splitSamplesPass(options, manifest, header, fixedColumns, columns[9:], samples, first, last); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitSamplesPass writes the samples [first, last) in one pass over the input
+func splitSamplesPass(options SplitOptions, manifest *SplitManifest, header []string, fixedColumns string, names []string, samples []*splitOutput, first, last int) error {
+	batch := samples[first:last]
+	defer func() {
+		for _, output := range batch {
+			output.Close()
+		}
+	}()
+
+	for i, output := range batch {
+		sampleHeader := append(header[:len(header)-1:len(header)-1], fixedColumns+"\t"+names[first+i])
+		if err := output.open(options.BGZF, sampleHeader, false); err != nil {
+			return fmt.Errorf("Error creating output file: %v", err)
+		}
+
+		s := fmt.Sprintf("Writing %s to %s\n", names[first+i], output.path)
+		LoggerDebug(s)
+	}
+
+	reader, err := OpenVCF(options.InputVCF)
+	if err != nil {
+		return fmt.Errorf("Error opening input file: %v", err)
+	}
+	defer reader.Close()
+
+	progress := NewProgress("split", reader.Size)
+	scanner := GetScaner(reader.Reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "\t", 10)
+		if len(parts) < 10 {
+			continue
+		}
+		progress.Update(1, reader.BytesRead())
+
+		recordSite := strings.Join(parts[:9], "\t")
+		for i, sample := range strings.Split(parts[9], "\t") {
+			if i >= last {
+				break
+			}
+			if i < first {
+				continue
+			}
+			output := samples[i]
+			fmt.Fprintln(output.writer, recordSite+"\t"+sample)
+			manifest.Files[output.index].Records++
+		}
+	}
+	progress.Close()
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error reading input: %v", err)
+	}
+
+	for _, output := range batch {
+		if err := output.Close(); err != nil {
+			return fmt.Errorf("Error writing output file: %v", err)
+		}
+	}
+	return nil
+}
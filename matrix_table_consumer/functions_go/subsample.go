@@ -0,0 +1,183 @@
+package functions_go
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func subsampleReportJSON(report *SubsampleReport) string {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		s := fmt.Sprintf("JSON conversion error: %v\n", err)
+		LoggerError(s)
+		return "{}"
+	}
+	return string(data)
+}
+
+// thinning keeps the first record and then the records at least distance bp after the last kept one
+type thinning struct {
+	distance int64
+	chrom    string
+	lastPos  int64
+	// previousPos is the position of the previous record to check the order
+	previousPos int64
+	// seen holds the contigs before the current one, the records of a contig must be together
+	seen map[string]bool
+}
+
+// keep checks a record, the input must be sorted by position within each contig
+// and the records of each contig must be together
+func (t *thinning) keep(line string) (bool, error) {
+	parts := strings.SplitN(line, "\t", 3)
+	if len(parts) < 2 {
+		return false, nil
+	}
+	pos, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false, nil
+	}
+
+	if parts[0] != t.chrom {
+		if t.seen[parts[0]] {
+			return false, fmt.Errorf("input is not sorted: %s:%d after %s:%d, the records of %s are not together", parts[0], pos, t.chrom, t.previousPos, parts[0])
+		}
+		if t.chrom != "" {
+			t.seen[t.chrom] = true
+		}
+		t.chrom = parts[0]
+		t.lastPos = pos
+		t.previousPos = pos
+		return true, nil
+	}
+	if pos < t.previousPos {
+		return false, fmt.Errorf("input is not sorted: %s:%d after %s:%d", parts[0], pos, t.chrom, t.previousPos)
+	}
+	t.previousPos = pos
+	if pos-t.lastPos < t.distance {
+		return false, nil
+	}
+	t.lastPos = pos
+	return true, nil
+}
+
+// Subsample writes a reproducible random subset of the records: each record with the probability
+// Fraction, or exactly Count records chosen by reservoir sampling (they are kept in memory and written
+// in the input order). With ThinDistance at most one record per ThinDistance bp of each contig is kept
+// before sampling. The header is copied, the report is returned in JSON format
+func Subsample(options SubsampleOptions) string {
+	report := &SubsampleReport{}
+	fail := func(s string) string {
+		LoggerError(s)
+		report.Error = strings.TrimSpace(s)
+		return subsampleReportJSON(report)
+	}
+
+	switch {
+	case options.Fraction != 0 && options.Count != 0:
+		return fail("Fraction and count can not be used together\n")
+	case options.Fraction < 0 || options.Fraction > 1:
+		return fail(fmt.Sprintf("Invalid fraction %g, expected a value from 0 to 1\n", options.Fraction))
+	case options.Count < 0:
+		return fail(fmt.Sprintf("Invalid count %d\n", options.Count))
+	case options.ThinDistance < 0:
+		return fail(fmt.Sprintf("Invalid thinning distance %d\n", options.ThinDistance))
+	case options.Fraction == 0 && options.Count == 0 && options.ThinDistance == 0:
+		return fail("Fraction, count or thinning distance is required\n")
+	}
+
+	reader, err := OpenVCF(options.InputVCF)
+	if err != nil {
+		return fail(fmt.Sprintf("Error opening input file: %v\n", err))
+	}
+	defer reader.Close()
+
+	outputFile, err := os.Create(options.OutputVCF)
+	if err != nil {
+		return fail(fmt.Sprintf("Error creating file: %v\n", err))
+	}
+	defer outputFile.Close()
+
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	random := rand.New(rand.NewPCG(uint64(options.Seed), 0))
+	var thin *thinning
+	if options.ThinDistance > 0 {
+		thin = &thinning{distance: options.ThinDistance, seen: make(map[string]bool)}
+	}
+	var reservoir []sampledLine
+	var candidates int64 = 0
+
+	progress := NewProgress("subsample", reader.Size)
+	scanner := GetScaner(reader.Reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			fmt.Fprintln(writer, line)
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		report.InputRows++
+		progress.Update(1, reader.BytesRead())
+
+		if thin != nil {
+			keep, err := thin.keep(line)
+			if err != nil {
+				progress.Close()
+				writer.Flush()
+				outputFile.Close()
+				os.Remove(options.OutputVCF)
+				return fail(fmt.Sprintf("Error thinning: %v\n", err))
+			}
+			if !keep {
+				continue
+			}
+			report.RowsAfterThinning++
+		}
+
+		switch {
+		case options.Count > 0:
+			// Algorithm R: the n-th candidate replaces a random item of the reservoir with the probability count/n
+			candidates++
+			if len(reservoir) < options.Count {
+				reservoir = append(reservoir, sampledLine{index: candidates, text: line})
+			} else if j := random.Int64N(candidates); j < int64(options.Count) {
+				reservoir[j] = sampledLine{index: candidates, text: line}
+			}
+		case options.Fraction > 0:
+			if random.Float64() < options.Fraction {
+				fmt.Fprintln(writer, line)
+				report.OutputRows++
+			}
+		default:
+			fmt.Fprintln(writer, line)
+			report.OutputRows++
+		}
+	}
+	progress.Close()
+
+	if err := scanner.Err(); err != nil {
+		return fail(fmt.Sprintf("Error reading input: %v\n", err))
+	}
+
+	slices.SortFunc(reservoir, func(a, b sampledLine) int {
+		return compareInt64(a.index, b.index)
+	})
+	for _, sampled := range reservoir {
+		fmt.Fprintln(writer, sampled.text)
+		report.OutputRows++
+	}
+
+	s := fmt.Sprintf("Kept %d of %d records\n", report.OutputRows, report.InputRows)
+	LoggerInfo(s)
+	return subsampleReportJSON(report)
+}
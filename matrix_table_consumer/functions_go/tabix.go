@@ -0,0 +1,174 @@
+package functions_go
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// tabixMaxPosition is the largest position supported by the binning scheme of tabix
+const tabixMaxPosition = 1 << 29
+
+// loadTabixIndex reads a .tbi index
+func loadTabixIndex(path string) (*tabixIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the index: %v", err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the index: %v", err)
+	}
+	defer gr.Close()
+
+	data, err := io.ReadAll(gr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the index: %v", err)
+	}
+
+	reader := bytes.NewReader(data)
+	read := func(value any) error {
+		return binary.Read(reader, binary.LittleEndian, value)
+	}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != "TBI\x01" {
+		return nil, fmt.Errorf("%s is not a tabix index", path)
+	}
+
+	// n_ref, format, col_seq, col_beg, col_end, meta, skip, l_nm
+	fields := make([]int32, 8)
+	if err := read(fields); err != nil {
+		return nil, fmt.Errorf("invalid tabix index: %v", err)
+	}
+	refsCount, namesLength := int(fields[0]), int(fields[7])
+
+	names := make([]byte, namesLength)
+	if _, err := io.ReadFull(reader, names); err != nil {
+		return nil, fmt.Errorf("invalid tabix index: %v", err)
+	}
+
+	index := &tabixIndex{
+		refs:   make(map[string]int),
+		bins:   make([]map[uint32][]tabixChunk, refsCount),
+		linear: make([][]uint64, refsCount),
+	}
+	for name := range bytes.SplitSeq(bytes.TrimRight(names, "\x00"), []byte{0}) {
+		index.refs[string(name)] = len(index.names)
+		index.names = append(index.names, string(name))
+	}
+
+	for ref := range refsCount {
+		var binsCount int32
+		if err := read(&binsCount); err != nil {
+			return nil, fmt.Errorf("invalid tabix index: %v", err)
+		}
+
+		index.bins[ref] = make(map[uint32][]tabixChunk, binsCount)
+		for range binsCount {
+			var bin uint32
+			var chunksCount int32
+			if err := read(&bin); err != nil {
+				return nil, fmt.Errorf("invalid tabix index: %v", err)
+			}
+			if err := read(&chunksCount); err != nil {
+				return nil, fmt.Errorf("invalid tabix index: %v", err)
+			}
+
+			offsets := make([]uint64, 2*chunksCount)
+			if err := read(offsets); err != nil {
+				return nil, fmt.Errorf("invalid tabix index: %v", err)
+			}
+			chunks := make([]tabixChunk, chunksCount)
+			for i := range chunks {
+				chunks[i] = tabixChunk{begin: offsets[2*i], end: offsets[2*i+1]}
+			}
+			index.bins[ref][bin] = chunks
+		}
+
+		var intervalsCount int32
+		if err := read(&intervalsCount); err != nil {
+			return nil, fmt.Errorf("invalid tabix index: %v", err)
+		}
+		index.linear[ref] = make([]uint64, intervalsCount)
+		if err := read(index.linear[ref]); err != nil {
+			return nil, fmt.Errorf("invalid tabix index: %v", err)
+		}
+	}
+
+	return index, nil
+}
+
+// regionToBins returns the bins that may contain records overlapping [begin, end) (0-based)
+func regionToBins(begin, end int64) []uint32 {
+	end--
+	bins := []uint32{0}
+	for _, level := range []struct {
+		offset int64
+		shift  uint
+	}{{1, 26}, {9, 23}, {73, 20}, {585, 17}, {4681, 14}} {
+		for k := level.offset + begin>>level.shift; k <= level.offset+end>>level.shift; k++ {
+			bins = append(bins, uint32(k))
+		}
+	}
+	return bins
+}
+
+// query returns sorted non overlapping chunks with records of the region
+func (index *tabixIndex) query(region Region) []tabixChunk {
+	ref, exists := index.refs[region.Chrom]
+	if !exists {
+		return nil
+	}
+
+	begin := max(region.Start-1, 0)
+	end := min(region.End, tabixMaxPosition)
+	if begin >= end {
+		return nil
+	}
+
+	// The linear index gives the smallest offset of records overlapping the 16 kb window of begin
+	var minOffset uint64
+	if linear := index.linear[ref]; len(linear) > 0 {
+		minOffset = linear[min(int(begin>>14), len(linear)-1)]
+	}
+
+	var chunks []tabixChunk
+	for _, bin := range regionToBins(begin, end) {
+		for _, chunk := range index.bins[ref][bin] {
+			if chunk.end > minOffset {
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+	return mergeChunks(chunks)
+}
+
+// mergeChunks sorts chunks and joins the overlapping ones
+func mergeChunks(chunks []tabixChunk) []tabixChunk {
+	slices.SortFunc(chunks, func(a, b tabixChunk) int {
+		if a.begin < b.begin {
+			return -1
+		}
+		if a.begin > b.begin {
+			return 1
+		}
+		return 0
+	})
+
+	merged := make([]tabixChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		if last := len(merged) - 1; last >= 0 && chunk.begin <= merged[last].end {
+			merged[last].end = max(merged[last].end, chunk.end)
+			continue
+		}
+		merged = append(merged, chunk)
+	}
+	return merged
+}
@@ -1,7 +1,12 @@
 package functions_go
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"io"
+	"os"
 	"time"
 )
 
@@ -17,6 +22,10 @@ type VCFRow struct {
 	InfoFields map[string]string
 	Pos        int32
 	Qual       int8
+
+	qual        string
+	header      *VCFHeader
+	formatIndex map[string]int
 }
 
 type VCFRowJSON struct {
@@ -43,6 +52,200 @@ type VCFRecordWithSamples struct {
 	Info    string
 	Format  string
 	Samples map[string]string
+	// input is the index of the Merge input the record was read from
+	input int
+}
+
+// MergeOptions are the parameters of Merge
+type MergeOptions struct {
+	VCF1      string
+	VCF2      string
+	OutputVCF string
+	// FileWithVCFs has one input path per line, "." if VCF1 and VCF2 are merged
+	FileWithVCFs string
+	// Multiallelics decides which records at the same position are merged into one record:
+	// none, snps, indels, both (default) or all
+	Multiallelics string
+	// QualRule combines QUAL: max (default), min or avg
+	QualRule string
+	// FilterRule combines FILTER: union (default) or pass (PASS if any record passes)
+	FilterRule string
+	// AlleleCounts combines AC, AN and AF: recompute (default, from the merged genotypes) or sum
+	AlleleCounts string
+	// InfoRules overrides the rules of INFO keys, for example "DP:sum,DB:join".
+	// The rules are first, join, sum, min, max and avg
+	InfoRules string
+}
+
+// mergeRules combine the ID, QUAL, FILTER and INFO of the records merged into one record
+type mergeRules struct {
+	qual         string
+	filter       string
+	alleleCounts string
+	info         map[string]string
+	header       *VCFHeader
+}
+
+// alleleGroup is a group of records at one position merged into one record
+type alleleGroup struct {
+	kind    int
+	ref     string
+	alts    []string
+	records []*VCFRecordWithSamples
+	// mappings[i] maps the allele indexes of records[i] to the indexes of the merged alleles
+	mappings [][]int
+}
+
+// mergeInput is a sorted input of Merge, the inputs are read in lockstep
+type mergeInput struct {
+	path    string
+	reader  *VCFReader
+	samples []string
+	index   int
+	// record is the current record, nil at the end of the input
+	record *VCFRecordWithSamples
+	contig contigKey
+	pos    int
+}
+
+// HeaderField describes an ##INFO or ##FORMAT header line
+type HeaderField struct {
+	ID          string
+	Number      string
+	Type        string
+	Description string
+}
+
+// VCFHeader holds the INFO and FORMAT definitions of a VCF header
+type VCFHeader struct {
+	Info    map[string]HeaderField
+	Format  map[string]HeaderField
+	Samples []string
+}
+
+// FilterOptions configures Filter. The outputs of FilterMulti are read from JSON objects with the
+// per-output fields (name, include, exclude, output_vcf, soft_filter, missing_policy, samples,
+// samples_file, sites_only, recompute_tags, lenient_header)
+type FilterOptions struct {
+	// Name identifies the output of FilterMulti in the report
+	Name      string `json:"name"`
+	Include   string `json:"include"`
+	Exclude   string `json:"exclude"`
+	InputVCF  string `json:"-"`
+	OutputVCF string `json:"output_vcf"`
+	// SoftFilter is written to the FILTER column of failing records instead of dropping them
+	SoftFilter string `json:"soft_filter"`
+	// Regions is a comma separated list of chr, chr:start or chr:start-end (1-based, inclusive)
+	Regions string `json:"-"`
+	// RegionsFile is a BED file with target intervals
+	RegionsFile string `json:"-"`
+	// MissingPolicy decides what happens to records where the expression reads a missing value
+	MissingPolicy string `json:"missing_policy"`
+	// Samples is a comma separated list of samples to keep, a leading ^ drops them instead
+	Samples string `json:"samples"`
+	// SamplesFile has one sample per line, a leading ^ in the path drops them instead
+	SamplesFile string `json:"samples_file"`
+	// SitesOnly drops the FORMAT and sample columns
+	SitesOnly bool `json:"sites_only"`
+	// RecomputeTags sets AC, AN and AF from the genotypes of the kept samples
+	RecomputeTags bool `json:"recompute_tags"`
+	// LenientHeader logs a warning for INFO and FORMAT fields without a header definition instead of an error
+	LenientHeader bool `json:"lenient_header"`
+	NumCPU        int  `json:"-"`
+}
+
+// sampleSubset selects the sample columns written by Filter
+type sampleSubset struct {
+	names     []string
+	exclude   bool
+	sitesOnly bool
+	recompute bool
+	// columns are the indexes of the kept samples, nil keeps all of them
+	columns []int
+}
+
+// FilterReport is the summary of a Filter run.
+// PassedRows + FailedRows = InputRows, rows with evaluation errors are counted as failed
+type FilterReport struct {
+	Name       string              `json:"name,omitempty"`
+	OutputVCF  string              `json:"output_vcf,omitempty"`
+	InputRows  int64               `json:"input_rows"`
+	PassedRows int64               `json:"passed_rows"`
+	FailedRows int64               `json:"failed_rows"`
+	ErrorRows  int64               `json:"error_rows"`
+	Errors     []FilterErrorReport `json:"errors"`
+	Clauses    []ClauseReport      `json:"clauses"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// MultiFilterReport is the summary of a FilterMulti run with a report for each output
+type MultiFilterReport struct {
+	InputRows int64          `json:"input_rows"`
+	Outputs   []FilterReport `json:"outputs"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// FilterErrorReport groups the rows that failed with the same evaluation error
+type FilterErrorReport struct {
+	Message string  `json:"message"`
+	Count   int64   `json:"count"`
+	Lines   []int64 `json:"lines"`
+}
+
+// ClauseReport is the number of rows for which a top-level clause of the expression is true
+type ClauseReport struct {
+	Clause string `json:"clause"`
+	Passed int64  `json:"passed"`
+}
+
+// filterStats is collected by each filter worker and merged into FilterReport
+type filterStats struct {
+	input   int64
+	passed  int64
+	errors  map[string]*FilterErrorReport
+	clauses []int64
+}
+
+// filterResult is a record written to the output with the given index
+type filterResult struct {
+	output int
+	text   string
+}
+
+// filterOutput is an output file of Filter or FilterMulti
+type filterOutput struct {
+	filter            *rowFilter
+	path              string
+	file              *os.File
+	writer            *bufio.Writer
+	filterHeaderFound bool
+}
+
+// vcfLine is a line of the input file with its 1-based line number
+type vcfLine struct {
+	text   string
+	number int64
+}
+
+// FilterFunction is a function that can be called from filter expressions
+type FilterFunction struct {
+	call    func(row *VCFRow, args []any) (any, error)
+	params  []int
+	returns int
+}
+
+// Expression is a compiled filter expression
+type Expression struct {
+	root   exprNode
+	source string
+}
+
+// expressionValidator checks an expression against the header, warned holds the undefined fields
+// already logged in lenient mode
+type expressionValidator struct {
+	header  *VCFHeader
+	lenient bool
+	warned  map[string]bool
 }
 
 // Tqdm introduces progress bar
@@ -67,3 +270,277 @@ type Tqdm struct {
 
 // Option defines a function to configure Tqdm
 type Option func(*Tqdm)
+
+// ProgressCallback receives progress updates of long running operations
+type ProgressCallback func(operation string, records int64, bytes int64, total int64)
+
+// Progress reports the progress of an operation to the registered callback
+type Progress struct {
+	lastReport time.Time
+	operation  string
+	bar        *Tqdm
+
+	records     int64
+	bytes       int64
+	total       int64
+	minInterval time.Duration
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+// VCFReader reads plain or gzip compressed VCF files
+type VCFReader struct {
+	*bufio.Reader
+	file    *os.File
+	gz      *gzip.Reader
+	counter *countingReader
+
+	Size int64
+}
+
+// Region is an interval of a contig, 1-based with inclusive end
+type Region struct {
+	Chrom string
+	Start int64
+	End   int64
+}
+
+// intervalTree is a balanced tree built over intervals sorted by start,
+// maxEnd holds the maximum end of the subtree rooted at each interval
+type intervalTree struct {
+	intervals []Region
+	maxEnd    []int64
+}
+
+// RegionSet holds the regions of each contig
+type RegionSet struct {
+	contigs []string
+	trees   map[string]*intervalTree
+}
+
+// bgzfReader reads BGZF compressed files block by block and keeps virtual offsets
+type bgzfReader struct {
+	file     *os.File
+	reader   *bufio.Reader
+	inflater io.ReadCloser
+
+	data            []byte
+	pos             int
+	blockOffset     int64
+	nextBlockOffset int64
+	bytesRead       int64
+}
+
+// bgzfWriter writes BGZF compressed files that can be indexed with tabix
+type bgzfWriter struct {
+	file       *os.File
+	deflater   *flate.Writer
+	data       []byte
+	compressed bytes.Buffer
+}
+
+// SplitOptions configures Split
+type SplitOptions struct {
+	InputVCF string
+	// OutputDir is created if it does not exist
+	OutputDir string
+	// BySample writes one single-sample VCF per sample instead of one VCF per contig
+	BySample bool
+	// BGZF compresses the outputs with bgzip (.vcf.gz)
+	BGZF bool
+	// MaxOpenFiles limits the number of output files open at once (0 - DefaultSplitMaxOpenFiles).
+	// By contig the least recently used output is closed and opened again if the contig comes back,
+	// by sample the samples are split in several passes over the input
+	MaxOpenFiles int
+}
+
+// SplitManifest lists the files written by Split
+type SplitManifest struct {
+	Files []SplitFile `json:"files"`
+	Error string      `json:"error,omitempty"`
+}
+
+// SplitFile is an output of Split: the contig or the sample, the path and the number of records
+type SplitFile struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Records int64  `json:"records"`
+}
+
+// SubsampleOptions configures Subsample. Fraction and Count can not be used together,
+// ThinDistance is applied before random sampling
+type SubsampleOptions struct {
+	InputVCF  string
+	OutputVCF string
+	// Fraction keeps each record with this probability
+	Fraction float64
+	// Count keeps exactly this number of records (all of them if the input has fewer)
+	Count int
+	// Seed makes the sampling reproducible
+	Seed int64
+	// ThinDistance keeps at most one record per ThinDistance bp of each contig
+	ThinDistance int64
+}
+
+// SubsampleReport is the summary of a Subsample run
+type SubsampleReport struct {
+	InputRows         int64  `json:"input_rows"`
+	RowsAfterThinning int64  `json:"rows_after_thinning,omitempty"`
+	OutputRows        int64  `json:"output_rows"`
+	Error             string `json:"error,omitempty"`
+}
+
+// DedupOptions configures Dedup
+type DedupOptions struct {
+	InputVCF  string
+	OutputVCF string
+	// Policy decides which records are duplicates: DedupExact, DedupSite or DedupAlleles
+	Policy string
+	// Keep decides which duplicate is written: KeepFirst or KeepHighestQual
+	Keep string
+}
+
+// DedupReport is the summary of a Dedup run
+type DedupReport struct {
+	InputRows  int64 `json:"input_rows"`
+	OutputRows int64 `json:"output_rows"`
+	// RemovedRows is the number of duplicates that were not written
+	RemovedRows int64 `json:"removed_rows"`
+	// DuplicateGroups is the number of records that had at least one duplicate
+	DuplicateGroups int64  `json:"duplicate_groups"`
+	Error           string `json:"error,omitempty"`
+}
+
+// dedupRecord is a record of the current position with its duplicate key
+type dedupRecord struct {
+	line       string
+	key        string
+	qual       float64
+	duplicates int
+}
+
+// SortOptions configures Sort
+type SortOptions struct {
+	InputVCF  string
+	OutputVCF string
+	// ChunkSize limits the number of records of a chunk, 0 - only the memory budget limits chunks
+	ChunkSize int
+	// Reference is a .fai index or a .dict sequence dictionary with the contig order,
+	// the ##contig header lines are used if it is empty
+	Reference string
+	// MemoryBudget is the memory in bytes for the records of all chunks in memory, DefaultSortMemory if 0
+	MemoryBudget int64
+	// NumCPU is the number of chunks sorted and written at the same time
+	NumCPU int
+	// TempDir is the directory for temporary files, the system temp directory if empty
+	TempDir string
+	// TempCompression is the gzip level of temporary files (1-9), 0 writes them uncompressed
+	TempCompression int
+	// MaxOpenFiles limits the number of temporary files merged at once (0 - DefaultSortMaxOpenFiles),
+	// more files are merged in rounds into larger temporary files
+	MaxOpenFiles int
+	// ByEnd orders the records with the same position, REF and ALT by END (the END of INFO or the end of REF),
+	// for structural variants that differ only in END. END is not parsed otherwise
+	ByEnd bool
+}
+
+// sortChunk is a part of the input sorted in memory and written to a temporary file
+type sortChunk struct {
+	records []VCFRecord
+	path    string
+}
+
+// sortMergeSource is a sorted temporary file read by the k-way merge of Sort
+type sortMergeSource struct {
+	reader *bufio.Reader
+	record VCFRecord
+	index  int
+}
+
+// SortCheckReport is the result of CheckSorted
+type SortCheckReport struct {
+	Sorted bool `json:"sorted"`
+	// InterleavedContigs is true if the records of a contig are not contiguous
+	InterleavedContigs bool `json:"interleaved_contigs"`
+	// FirstUnsorted is the first record that is before the previous record in the sort order
+	FirstUnsorted *UnsortedRecord `json:"first_unsorted,omitempty"`
+	// TieOrder is the first record at the same position as the previous record that is before it
+	// in the order of Sort (REF and ALT), it is a note and does not make the file unsorted
+	TieOrder *UnsortedRecord `json:"tie_order,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+type UnsortedRecord struct {
+	// Line is the line number in the file, header lines are counted
+	Line     int64  `json:"line"`
+	Previous string `json:"previous"`
+	Record   string `json:"record"`
+}
+
+// ContigOrder is the order of contigs used by Sort: from the reference (.fai or .dict),
+// from the ##contig header lines or the natural order for contigs that are not listed
+type ContigOrder struct {
+	index         map[string]int
+	fromReference bool
+}
+
+// contigKey is the position of a contig in ContigOrder
+type contigKey struct {
+	// listed is the index in the list, math.MaxInt for contigs that are not listed
+	listed int
+	// group of the natural order: 0 - numbered contigs, 1 - X, Y and MT, 2 - other contigs
+	group  int
+	number int
+	name   string
+}
+
+// sampledLine is a record kept by the reservoir with its position in the input
+type sampledLine struct {
+	index int64
+	text  string
+}
+
+// splitOutput is an output file of Split, writer is nil while the file is closed
+type splitOutput struct {
+	path   string
+	file   *os.File
+	bgzf   *bgzfWriter
+	writer *bufio.Writer
+	index  int
+}
+
+// tabixChunk is a range of virtual offsets of a BGZF file
+type tabixChunk struct {
+	begin uint64
+	end   uint64
+}
+
+// tabixIndex is a parsed .tbi index
+type tabixIndex struct {
+	names  []string
+	refs   map[string]int
+	bins   []map[uint32][]tabixChunk
+	linear [][]uint64
+}
+
+// RegionReader reads the header and the records of a VCF file overlapping the regions
+type RegionReader struct {
+	reader  *VCFReader
+	scanner *bufio.Scanner
+
+	bgzf       *bgzfReader
+	chunks     []tabixChunk
+	chunk      int
+	inChunk    bool
+	headerDone bool
+
+	regions *RegionSet
+	line    string
+	err     error
+
+	Size int64
+}
@@ -1,15 +1,79 @@
+import sys
+import json
 from datetime import datetime
 
+LEVELS = {
+    "DEBUG": 0,
+    "INFO": 1,
+    "WARNING": 2,
+    "ERROR": 3,
+    "OFF": 4,
+}
+
+# Same settings as the Go logger, changed by configure_logger
+_config = {
+    "level": LEVELS["INFO"],
+    "json_format": False,
+    "output": "stderr",
+}
+
 
 def get_time() -> str:
     return datetime.now().strftime("%d-%m-%Y %H:%M:%S")
 
 
+def parse_log_level(level: str) -> int:
+    level = level.strip().upper()
+    if level == "WARN":
+        level = "WARNING"
+    if level not in LEVELS:
+        raise ValueError(f"unknown log level '{level}'")
+    return LEVELS[level]
+
+
+def configure_logger(level: str = "INFO", json_format: bool = False, output: str = "stderr") -> None:
+    """Configures Python logs the same way as Go logs: level, JSON lines and output (stderr, stdout or file path)"""
+
+    _config["level"] = parse_log_level(level)
+    _config["json_format"] = json_format
+    _config["output"] = output or "stderr"
+
+
+def format_record(level: str, s: str) -> str:
+    s = s.rstrip("\n")
+    if _config["json_format"]:
+        # RFC 3339 time, as written by the Go logger
+        t = datetime.now().astimezone().isoformat(timespec="seconds").replace("+00:00", "Z")
+        return json.dumps({"time": t, "level": level, "message": s})
+    return f"[{get_time()}] - {level} - {s}"
+
+
+def write_log(level: str, s: str) -> None:
+    if LEVELS[level] < _config["level"]:
+        return
+
+    line = format_record(level, s) + "\n"
+    output = _config["output"]
+    if output == "stderr":
+        sys.stderr.write(line)
+    elif output == "stdout":
+        sys.stdout.write(line)
+    else:
+        with open(output, "a") as f:
+            f.write(line)
+
+
+def logger_debug(s: str) -> None:
+    write_log("DEBUG", s)
+
+
 def logger_info(s: str) -> None:
-    t = get_time()
-    print(f"[{t}] - INFO - {s}")
+    write_log("INFO", s)
+
+
+def logger_warning(s: str) -> None:
+    write_log("WARNING", s)
 
 
 def logger_error(s: str) -> None:
-    t = get_time()
-    print(f"[{t}] - ERROR - {s}")
+    write_log("ERROR", s)
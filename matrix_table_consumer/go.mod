@@ -2,8 +2,6 @@ module functions_go
 
 go 1.24.5
 
-require (
-	github.com/PHILIPP111007/govaluate v0.0.0-20250325060307-7625b7f8c03d // indirect
-	github.com/mattn/go-runewidth v0.0.9 // indirect
-	github.com/nsf/termbox-go v1.1.1 // indirect
-)
+require github.com/nsf/termbox-go v1.1.1
+
+require github.com/mattn/go-runewidth v0.0.9 // indirect
@@ -1,10 +1,19 @@
 package main
 
-import (
-	"C"
-)
+/*
+#include <stdlib.h>
+
+typedef void (*progress_callback)(const char* operation, long long records, long long bytes, long long total);
+
+static void call_progress_callback(progress_callback callback, const char* operation, long long records, long long bytes, long long total) {
+	callback(operation, records, bytes, total);
+}
+*/
+import "C"
 
 import (
+	"unsafe"
+
 	"functions_go/functions_go"
 	functions "functions_go/functions_go"
 )
@@ -17,11 +26,13 @@ func CollectAll(vcf_path_pointer *C.char, num_cpu int) *C.char {
 }
 
 //export Collect
-func Collect(num_rows int, start_row int, vcf_path_pointer *C.char, num_cpu int) *C.char {
+func Collect(num_rows int, start_row int, vcf_path_pointer *C.char, num_cpu int, regions_pointer *C.char, regions_file_pointer *C.char) *C.char {
 	vcf_path := C.GoString(vcf_path_pointer)
+	regions := C.GoString(regions_pointer)
+	regions_file := C.GoString(regions_file_pointer)
 
 	// return functions_go.Collect(num_rows, start_row, vcf_path, is_gzip, num_cpu)
-	return C.CString(functions_go.Collect(num_rows, start_row, vcf_path, num_cpu))
+	return C.CString(functions_go.Collect(num_rows, start_row, vcf_path, num_cpu, regions, regions_file))
 }
 
 //export Count
@@ -32,30 +43,120 @@ func Count(vcf_path_pointer *C.char) int {
 }
 
 //export Filter
-func Filter(include_pointer *C.char, input_vcf_path_pointer *C.char, output_vcf_path_pointer *C.char, num_cpu int) {
-	include := C.GoString(include_pointer)
-	input_vcf_path := C.GoString(input_vcf_path_pointer)
-	output_vcf_path := C.GoString(output_vcf_path_pointer)
+func Filter(include_pointer *C.char, input_vcf_path_pointer *C.char, output_vcf_path_pointer *C.char, num_cpu int, exclude_pointer *C.char, soft_filter_pointer *C.char, regions_pointer *C.char, regions_file_pointer *C.char, missing_policy_pointer *C.char, samples_pointer *C.char, samples_file_pointer *C.char, sites_only C.int, recompute_tags C.int, lenient_header C.int) *C.char {
+	options := functions_go.FilterOptions{
+		Include:       C.GoString(include_pointer),
+		Exclude:       C.GoString(exclude_pointer),
+		InputVCF:      C.GoString(input_vcf_path_pointer),
+		OutputVCF:     C.GoString(output_vcf_path_pointer),
+		SoftFilter:    C.GoString(soft_filter_pointer),
+		Regions:       C.GoString(regions_pointer),
+		RegionsFile:   C.GoString(regions_file_pointer),
+		MissingPolicy: C.GoString(missing_policy_pointer),
+		Samples:       C.GoString(samples_pointer),
+		SamplesFile:   C.GoString(samples_file_pointer),
+		SitesOnly:     sites_only != 0,
+		RecomputeTags: recompute_tags != 0,
+		LenientHeader: lenient_header != 0,
+		NumCPU:        num_cpu,
+	}
+
+	return C.CString(functions_go.Filter(options))
+}
 
-	functions_go.Filter(include, input_vcf_path, output_vcf_path, num_cpu)
+//export FilterMulti
+func FilterMulti(outputs_pointer *C.char, input_vcf_path_pointer *C.char, num_cpu int, regions_pointer *C.char, regions_file_pointer *C.char, missing_policy_pointer *C.char) *C.char {
+	options := functions_go.FilterOptions{
+		InputVCF:      C.GoString(input_vcf_path_pointer),
+		Regions:       C.GoString(regions_pointer),
+		RegionsFile:   C.GoString(regions_file_pointer),
+		MissingPolicy: C.GoString(missing_policy_pointer),
+		NumCPU:        num_cpu,
+	}
+
+	return C.CString(functions_go.FilterMulti(options, C.GoString(outputs_pointer)))
 }
 
 //export Merge
-func Merge(vcf1_pointer *C.char, vcf2_pointer *C.char, output_vcf_pointer *C.char, file_with_vcfs_pointer *C.char) {
-	vcf1 := C.GoString(vcf1_pointer)
-	vcf2 := C.GoString(vcf2_pointer)
-	output_vcf := C.GoString(output_vcf_pointer)
-	file_with_vcfs := C.GoString(file_with_vcfs_pointer)
-
-	functions_go.Merge(vcf1, vcf2, output_vcf, file_with_vcfs)
+func Merge(vcf1_pointer *C.char, vcf2_pointer *C.char, output_vcf_pointer *C.char, file_with_vcfs_pointer *C.char, multiallelics_pointer *C.char, qual_rule_pointer *C.char, filter_rule_pointer *C.char, allele_counts_pointer *C.char, info_rules_pointer *C.char) {
+	options := functions_go.MergeOptions{
+		VCF1:          C.GoString(vcf1_pointer),
+		VCF2:          C.GoString(vcf2_pointer),
+		OutputVCF:     C.GoString(output_vcf_pointer),
+		FileWithVCFs:  C.GoString(file_with_vcfs_pointer),
+		Multiallelics: C.GoString(multiallelics_pointer),
+		QualRule:      C.GoString(qual_rule_pointer),
+		FilterRule:    C.GoString(filter_rule_pointer),
+		AlleleCounts:  C.GoString(allele_counts_pointer),
+		InfoRules:     C.GoString(info_rules_pointer),
+	}
+
+	functions_go.Merge(options)
 }
 
 //export Sort
-func Sort(vcf_path_pointer, output_vcf_path_pointer *C.char, chunkSize int) {
-	vcf := C.GoString(vcf_path_pointer)
-	output_vcf := C.GoString(output_vcf_path_pointer)
+func Sort(vcf_path_pointer, output_vcf_path_pointer *C.char, chunkSize int, reference_pointer *C.char, memory C.longlong, num_cpu int, temp_dir_pointer *C.char, temp_compression C.int, max_open_files C.int, by_end C.int) {
+	options := functions_go.SortOptions{
+		InputVCF:        C.GoString(vcf_path_pointer),
+		OutputVCF:       C.GoString(output_vcf_path_pointer),
+		ChunkSize:       chunkSize,
+		Reference:       C.GoString(reference_pointer),
+		MemoryBudget:    int64(memory),
+		NumCPU:          num_cpu,
+		TempDir:         C.GoString(temp_dir_pointer),
+		TempCompression: int(temp_compression),
+		MaxOpenFiles:    int(max_open_files),
+		ByEnd:           by_end != 0,
+	}
+
+	functions_go.Sort(options)
+}
+
+//export Split
+func Split(vcf_path_pointer *C.char, output_dir_pointer *C.char, by_sample C.int, bgzf C.int, max_open_files C.int) *C.char {
+	options := functions_go.SplitOptions{
+		InputVCF:     C.GoString(vcf_path_pointer),
+		OutputDir:    C.GoString(output_dir_pointer),
+		BySample:     by_sample != 0,
+		BGZF:         bgzf != 0,
+		MaxOpenFiles: int(max_open_files),
+	}
+
+	return C.CString(functions_go.Split(options))
+}
 
-	functions_go.Sort(vcf, output_vcf, chunkSize)
+//export Subsample
+func Subsample(vcf_path_pointer *C.char, output_vcf_path_pointer *C.char, fraction float64, count int, seed int, thin_distance int) *C.char {
+	options := functions_go.SubsampleOptions{
+		InputVCF:     C.GoString(vcf_path_pointer),
+		OutputVCF:    C.GoString(output_vcf_path_pointer),
+		Fraction:     fraction,
+		Count:        count,
+		Seed:         int64(seed),
+		ThinDistance: int64(thin_distance),
+	}
+
+	return C.CString(functions_go.Subsample(options))
+}
+
+//export Dedup
+func Dedup(vcf_path_pointer *C.char, output_vcf_path_pointer *C.char, policy_pointer *C.char, keep_pointer *C.char) *C.char {
+	options := functions_go.DedupOptions{
+		InputVCF:  C.GoString(vcf_path_pointer),
+		OutputVCF: C.GoString(output_vcf_path_pointer),
+		Policy:    C.GoString(policy_pointer),
+		Keep:      C.GoString(keep_pointer),
+	}
+
+	return C.CString(functions_go.Dedup(options))
+}
+
+//export CheckSorted
+func CheckSorted(vcf_path_pointer *C.char, reference_pointer *C.char) *C.char {
+	vcf_path := C.GoString(vcf_path_pointer)
+	reference := C.GoString(reference_pointer)
+
+	return C.CString(functions_go.CheckSorted(vcf_path, reference))
 }
 
 //export View
@@ -65,4 +166,29 @@ func View(vcf_pointer *C.char) {
 	functions_go.ViewVCF(vcf)
 }
 
+//export SetLogger
+func SetLogger(level_pointer *C.char, json_format int, output_pointer *C.char) {
+	level := C.GoString(level_pointer)
+	output := C.GoString(output_pointer)
+
+	if err := functions_go.SetLogger(level, json_format != 0, output); err != nil {
+		functions_go.LoggerError(err.Error())
+	}
+}
+
+//export SetProgressCallback
+func SetProgressCallback(callback C.progress_callback) {
+	if callback == nil {
+		functions_go.SetProgressCallback(nil)
+		return
+	}
+
+	functions_go.SetProgressCallback(func(operation string, records int64, bytes int64, total int64) {
+		operation_pointer := C.CString(operation)
+		defer C.free(unsafe.Pointer(operation_pointer))
+
+		C.call_progress_callback(callback, operation_pointer, C.longlong(records), C.longlong(bytes), C.longlong(total))
+	})
+}
+
 func main() {}
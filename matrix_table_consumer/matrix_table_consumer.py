@@ -50,6 +50,8 @@ Collect.argtypes = [
     ctypes.c_int,
     ctypes.c_char_p,
     ctypes.c_int,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
 ]
 Collect.restype = ctypes.c_char_p
 
@@ -229,15 +231,31 @@ class MatrixTableConsumer:
         progress_bar.update(1)
         return mt
 
-    def collect(self, num_rows: int, num_cpu: int = 1) -> Rows:
-        """Gives `num_rows` rows from vcf file (it can also open vcf.gz)"""
+    def collect(
+        self,
+        num_rows: int,
+        num_cpu: int = 1,
+        regions: str = "",
+        regions_file: str = "",
+    ) -> Rows:
+        """Gives `num_rows` rows from vcf file (it can also open vcf.gz).
+        `regions` ("chr1:100-200,chr2") and `regions_file` (BED) keep only overlapping rows"""
 
         if not os.path.exists(self.vcf_path):
             logger_error("File not found")
             sys.exit(1)
 
         vcf_path_encoded = self.vcf_path.encode("utf-8")
-        s = Collect(num_rows, self.start_row, vcf_path_encoded, num_cpu)
+        regions_encoded = regions.encode("utf-8")
+        regions_file_encoded = regions_file.encode("utf-8")
+        s = Collect(
+            num_rows,
+            self.start_row,
+            vcf_path_encoded,
+            num_cpu,
+            regions_encoded,
+            regions_file_encoded,
+        )
         s = s.decode("utf-8")
         rows = json.loads(s)
         self.start_row += len(rows)
@@ -1,13 +1,13 @@
 import os
 import sys
+import json
 import argparse
 import ctypes
-from datetime import datetime
 
 from bio2zarr import vcf as vcf2zarr
 
 from .functions_py.index import index_vcf
-from .functions_py.logger import logger_error
+from .functions_py.logger import configure_logger, logger_error
 
 
 current_dir = os.path.dirname(__file__)
@@ -15,22 +15,64 @@ library_path = os.path.join(current_dir, "main.so")
 
 lib = ctypes.CDLL(library_path)
 Filter = lib.Filter
+FilterMulti = lib.FilterMulti
 Merge = lib.Merge
 Sort = lib.Sort
+Split = lib.Split
+Subsample = lib.Subsample
+Dedup = lib.Dedup
+CheckSorted = lib.CheckSorted
 View = lib.View
+SetProgressCallback = lib.SetProgressCallback
+SetLogger = lib.SetLogger
+
+# operation, records processed, bytes processed, total bytes (-1 if unknown)
+ProgressCallback = ctypes.CFUNCTYPE(
+    None,
+    ctypes.c_char_p,
+    ctypes.c_longlong,
+    ctypes.c_longlong,
+    ctypes.c_longlong,
+)
 
 Filter.argtypes = [
     ctypes.c_char_p,
     ctypes.c_char_p,
     ctypes.c_char_p,
     ctypes.c_int,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_int,
+    ctypes.c_int,
+    ctypes.c_int,
+]
+Filter.restype = ctypes.c_char_p
+
+FilterMulti.argtypes = [
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_int,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
 ]
-Filter.restype = None
+FilterMulti.restype = ctypes.c_char_p
 
 Merge.argtypes = [
     ctypes.c_char_p,
     ctypes.c_char_p,
     ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
 ]
 Merge.restype = None
 
@@ -38,30 +80,128 @@ Sort.argtypes = [
     ctypes.c_char_p,
     ctypes.c_char_p,
     ctypes.c_int,
+    ctypes.c_char_p,
+    ctypes.c_longlong,
+    ctypes.c_int,
+    ctypes.c_char_p,
+    ctypes.c_int,
+    ctypes.c_int,
+    ctypes.c_int,
 ]
 Sort.restype = None
 
+Split.argtypes = [
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_int,
+    ctypes.c_int,
+    ctypes.c_int,
+]
+Split.restype = ctypes.c_char_p
+
+Subsample.argtypes = [
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_double,
+    ctypes.c_longlong,
+    ctypes.c_longlong,
+    ctypes.c_longlong,
+]
+Subsample.restype = ctypes.c_char_p
+
+Dedup.argtypes = [
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+]
+Dedup.restype = ctypes.c_char_p
+
+CheckSorted.argtypes = [
+    ctypes.c_char_p,
+    ctypes.c_char_p,
+]
+CheckSorted.restype = ctypes.c_char_p
+
 View.argtypes = [
     ctypes.c_char_p,
 ]
 View.restype = None
 
+SetProgressCallback.argtypes = [ProgressCallback]
+SetProgressCallback.restype = None
+
+SetLogger.argtypes = [
+    ctypes.c_char_p,
+    ctypes.c_int,
+    ctypes.c_char_p,
+]
+SetLogger.restype = None
+
+# Keeps a reference to the registered callback so it is not garbage collected
+_progress_callback = None
+
+
+def set_logger(level: str = "INFO", json_format: bool = False, output: str = "stderr") -> None:
+    """Configures Go and Python logs: level (DEBUG, INFO, WARNING, ERROR, OFF), JSON lines and output (stderr, stdout or file path)"""
 
-def get_time() -> str:
-    return datetime.now().strftime("%d-%m-%Y %H:%M:%S")
+    level_encoded = level.encode("utf-8")
+    output_encoded = output.encode("utf-8")
 
+    SetLogger(level_encoded, int(json_format), output_encoded)
 
-def logger_info(s: str) -> None:
-    t = get_time()
-    print(f"[{t}] - INFO - {s}")
+    try:
+        configure_logger(level=level, json_format=json_format, output=output)
+    except ValueError:
+        # The unknown level is already logged by Go
+        pass
 
 
-def logger_error(s: str) -> None:
-    t = get_time()
-    print(f"[{t}] - ERROR - {s}")
+def set_progress_callback(callback) -> None:
+    """Registers `callback(operation, records, bytes, total)` for progress updates, None removes it"""
 
+    global _progress_callback
+
+    if callback is None:
+        _progress_callback = None
+        SetProgressCallback(ctypes.cast(None, ProgressCallback))
+        return
+
+    def wrapper(operation: bytes, records: int, bytes_processed: int, total: int) -> None:
+        callback(operation.decode("utf-8"), records, bytes_processed, total)
+
+    _progress_callback = ProgressCallback(wrapper)
+    SetProgressCallback(_progress_callback)
+
+
+def filter(
+    include: str = "",
+    input_vcf: str = "",
+    output_vcf: str = "",
+    num_cpu: int = 1,
+    exclude: str = "",
+    soft_filter: str = "",
+    regions: str = "",
+    regions_file: str = "",
+    missing_policy: str = "",
+    samples: str = "",
+    samples_file: str = "",
+    sites_only: bool = False,
+    recompute_tags: bool = False,
+    lenient_header: bool = False,
+) -> dict:
+    """Keeps records matching `include` or not matching `exclude`.
+    With `soft_filter` all records are kept and failing ones get this name in the FILTER column.
+    `regions` ("chr1:100-200,chr2") and `regions_file` (BED) keep only records overlapping them.
+    Comparisons with missing values are false, `missing_policy` ("pass", "fail" or "error")
+    overrides the result of records where the expression reads a missing value.
+    `samples` ("NA1,NA2", "^NA3" to drop) or `samples_file` (one sample per line) keep only these samples,
+    `sites_only` drops the genotype columns and `recompute_tags` sets AC, AN and AF from the kept genotypes.
+    INFO and FORMAT fields of the expression must be defined in the header, with `lenient_header`
+    undefined fields are only logged as warnings.
+    Without an expression all records are kept.
+    Returns the report: input, passed and failed rows, evaluation errors and passed rows of each clause."""
 
-def filter(include: str, input_vcf: str, output_vcf: str, num_cpu: int) -> None:
     if not os.path.exists(input_vcf):
         logger_error("Input vcf not found")
         sys.exit(1)
@@ -73,8 +213,71 @@ def filter(include: str, input_vcf: str, output_vcf: str, num_cpu: int) -> None:
     include_encoded = include.encode("utf-8")
     input_vcf_encoded = input_vcf.encode("utf-8")
     output_vcf_encoded = output_vcf.encode("utf-8")
+    exclude_encoded = exclude.encode("utf-8")
+    soft_filter_encoded = soft_filter.encode("utf-8")
+    regions_encoded = regions.encode("utf-8")
+    regions_file_encoded = regions_file.encode("utf-8")
+    missing_policy_encoded = missing_policy.encode("utf-8")
+    samples_encoded = samples.encode("utf-8")
+    samples_file_encoded = samples_file.encode("utf-8")
+
+    report = Filter(
+        include_encoded,
+        input_vcf_encoded,
+        output_vcf_encoded,
+        num_cpu,
+        exclude_encoded,
+        soft_filter_encoded,
+        regions_encoded,
+        regions_file_encoded,
+        missing_policy_encoded,
+        samples_encoded,
+        samples_file_encoded,
+        int(sites_only),
+        int(recompute_tags),
+        int(lenient_header),
+    )
+    return json.loads(report.decode("utf-8"))
+
+
+def filter_multi(
+    outputs: list,
+    input_vcf: str = "",
+    num_cpu: int = 1,
+    regions: str = "",
+    regions_file: str = "",
+    missing_policy: str = "",
+) -> dict:
+    """Filters `input_vcf` into several outputs in a single pass.
+    `outputs` is a list of dicts with "name", "include" or "exclude", "output_vcf" and optionally
+    "soft_filter", "missing_policy", "samples", "samples_file", "sites_only", "recompute_tags" and "lenient_header".
+    Every record is written to all outputs whose expression it passes.
+    Returns the report with the input rows and the report of each output."""
 
-    Filter(include_encoded, input_vcf_encoded, output_vcf_encoded, num_cpu)
+    if not os.path.exists(input_vcf):
+        logger_error("Input vcf not found")
+        sys.exit(1)
+
+    for output in outputs:
+        if os.path.exists(output.get("output_vcf", "")):
+            logger_error(f"File output vcf {output['output_vcf']} already exists")
+            sys.exit(1)
+
+    outputs_encoded = json.dumps(outputs).encode("utf-8")
+    input_vcf_encoded = input_vcf.encode("utf-8")
+    regions_encoded = regions.encode("utf-8")
+    regions_file_encoded = regions_file.encode("utf-8")
+    missing_policy_encoded = missing_policy.encode("utf-8")
+
+    report = FilterMulti(
+        outputs_encoded,
+        input_vcf_encoded,
+        num_cpu,
+        regions_encoded,
+        regions_file_encoded,
+        missing_policy_encoded,
+    )
+    return json.loads(report.decode("utf-8"))
 
 
 def merge(
@@ -82,7 +285,24 @@ def merge(
     vcf2: str = "",
     output_vcf: str = "",
     file_with_vcfs: str = ".",
+    multiallelics: str = "both",
+    qual_rule: str = "max",
+    filter_rule: str = "union",
+    allele_counts: str = "recompute",
+    info_rules: str = "",
 ) -> None:
+    """Merges coordinate-sorted `vcf1` and `vcf2` or the files listed in `file_with_vcfs` (one path per line).
+    The inputs are read in lockstep, only the current record of each input is kept in memory.
+
+    Records at the same position with the same alleles are always merged, `multiallelics` decides which
+    other records are merged into multiallelic records: "none", "snps", "indels", "both" or "all".
+
+    The IDs of the merged records are joined, `qual_rule` ("max", "min" or "avg") combines QUAL, `filter_rule`
+    is "union" (PASS only if all records pass) or "pass" (PASS if any record passes). AC, AN and AF are
+    recomputed from the merged genotypes or summed (`allele_counts` "recompute" or "sum"). Other INFO keys take
+    the first value, Number=A and Number=R keys are combined per allele and Number=. keys are joined;
+    `info_rules` such as "DP:sum,DB:join" override the rules (first, join, sum, min, max or avg)."""
+
     if vcf1 and not os.path.exists(vcf1):
         logger_error("Input vcf not found")
         sys.exit(1)
@@ -99,8 +319,23 @@ def merge(
     vcf2_encoded = vcf2.encode("utf-8")
     output_vcf_encoded = output_vcf.encode("utf-8")
     file_with_vcfs_encoded = file_with_vcfs.encode("utf-8")
+    multiallelics_encoded = multiallelics.encode("utf-8")
+    qual_rule_encoded = qual_rule.encode("utf-8")
+    filter_rule_encoded = filter_rule.encode("utf-8")
+    allele_counts_encoded = allele_counts.encode("utf-8")
+    info_rules_encoded = info_rules.encode("utf-8")
 
-    Merge(vcf1_encoded, vcf2_encoded, output_vcf_encoded, file_with_vcfs_encoded)
+    Merge(
+        vcf1_encoded,
+        vcf2_encoded,
+        output_vcf_encoded,
+        file_with_vcfs_encoded,
+        multiallelics_encoded,
+        qual_rule_encoded,
+        filter_rule_encoded,
+        allele_counts_encoded,
+        info_rules_encoded,
+    )
 
 
 def view(vcf_path: str):
@@ -125,15 +360,166 @@ def save_vcf_as_zarr(vcf_path: str, output_vcz: str, num_cpu: int, show_progress
     )
 
 
-def sort(vcf_path: str, output_vcf: str, chunk_size: int):
+def parse_memory(memory: int | str) -> int:
+    """Converts a memory size such as 512M or 2G to bytes, numbers are bytes."""
+
+    if isinstance(memory, int):
+        return memory
+
+    units = {"K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+    value = memory.strip().upper().removesuffix("B")
+    if value and value[-1] in units:
+        return int(float(value[:-1]) * units[value[-1]])
+    return int(value)
+
+
+def sort(
+    vcf_path: str,
+    output_vcf: str,
+    chunk_size: int = 0,
+    reference: str = "",
+    memory: int | str = 0,
+    num_cpu: int = 1,
+    temp_dir: str = "",
+    temp_compression: int = 1,
+    max_open_files: int = 0,
+    by_end: bool = False,
+):
+    """Sorts `vcf_path` by contig and position. The contig order is taken from `reference`
+    (a .fai index or a .dict sequence dictionary) or from the ##contig header lines,
+    contigs that are not listed follow in the natural order (1, 2, ..., X, Y, MT, other contigs by name).
+
+    The records are sorted in chunks that fit in `memory` (bytes or a size such as 2G, 1G by default),
+    `num_cpu` chunks are sorted and written to `temp_dir` at once. `chunk_size` limits the number of
+    records of a chunk (0 - no limit), `temp_compression` is the gzip level of the temp files (0 - uncompressed).
+    At most `max_open_files` temp files (0 - 128) are merged at once, more files are merged in rounds.
+
+    Records at the same position are ordered by REF and ALT. With `by_end` records with the same REF and ALT
+    are also ordered by END (structural variants), END is not parsed otherwise."""
+
     if not os.path.exists(vcf_path):
         logger_error("Input vcf not found")
         sys.exit(1)
 
+    if reference and not os.path.exists(reference):
+        logger_error("Reference contig order file not found")
+        sys.exit(1)
+
+    if temp_dir and not os.path.isdir(temp_dir):
+        logger_error("Temp dir not found")
+        sys.exit(1)
+
     vcf_path_encoded = vcf_path.encode("utf-8")
     output_vcf_path_encoded = output_vcf.encode("utf-8")
+    reference_encoded = reference.encode("utf-8")
+    temp_dir_encoded = temp_dir.encode("utf-8")
+
+    Sort(
+        vcf_path_encoded,
+        output_vcf_path_encoded,
+        chunk_size,
+        reference_encoded,
+        parse_memory(memory),
+        num_cpu,
+        temp_dir_encoded,
+        temp_compression,
+        max_open_files,
+        int(by_end),
+    )
+
 
-    Sort(vcf_path_encoded, output_vcf_path_encoded, chunk_size)
+def check_sorted(vcf_path: str, reference: str = "") -> dict:
+    """Checks that `vcf_path` is sorted in the contig order of `sort` (`reference` or the ##contig header lines).
+    Returns the report: whether the file is sorted, the first out-of-order record (line number,
+    previous and offending CHROM:POS) and whether the records of a contig are interleaved with other contigs."""
+
+    if not os.path.exists(vcf_path):
+        logger_error("Input vcf not found")
+        sys.exit(1)
+
+    if reference and not os.path.exists(reference):
+        logger_error("Reference contig order file not found")
+        sys.exit(1)
+
+    vcf_path_encoded = vcf_path.encode("utf-8")
+    reference_encoded = reference.encode("utf-8")
+
+    report = CheckSorted(vcf_path_encoded, reference_encoded)
+    return json.loads(report.decode("utf-8"))
+
+
+def split(
+    vcf_path: str,
+    output_dir: str,
+    by_sample: bool = False,
+    bgzip: bool = False,
+    max_open_files: int = 0,
+) -> dict:
+    """Writes one VCF per contig (or one single-sample VCF per sample with `by_sample`) to `output_dir`
+    in a single pass, `bgzip` compresses the outputs (.vcf.gz).
+    At most `max_open_files` outputs (0 - 256) are open at once, samples above the limit are written
+    in further passes over the input.
+    Returns the manifest: the contig or sample, the path and the number of records of each file."""
+
+    if not os.path.exists(vcf_path):
+        logger_error("Input vcf not found")
+        sys.exit(1)
+
+    vcf_path_encoded = vcf_path.encode("utf-8")
+    output_dir_encoded = output_dir.encode("utf-8")
+
+    manifest = Split(vcf_path_encoded, output_dir_encoded, int(by_sample), int(bgzip), max_open_files)
+    return json.loads(manifest.decode("utf-8"))
+
+
+def subsample(
+    vcf_path: str,
+    output_vcf: str,
+    fraction: float = 0.0,
+    count: int = 0,
+    seed: int = 0,
+    thin_distance: int = 0,
+) -> dict:
+    """Writes a reproducible random subset of the records: each one with the probability `fraction`,
+    or exactly `count` records (reservoir sampling). `thin_distance` keeps at most one record per
+    `thin_distance` bp of each contig before sampling (the input must be sorted).
+    Returns the report: input rows, rows after thinning and output rows."""
+
+    if not os.path.exists(vcf_path):
+        logger_error("Input vcf not found")
+        sys.exit(1)
+
+    if os.path.exists(output_vcf):
+        logger_error("File output vcf already exists")
+        sys.exit(1)
+
+    vcf_path_encoded = vcf_path.encode("utf-8")
+    output_vcf_encoded = output_vcf.encode("utf-8")
+
+    report = Subsample(vcf_path_encoded, output_vcf_encoded, fraction, count, seed, thin_distance)
+    return json.loads(report.decode("utf-8"))
+
+
+def dedup(vcf_path: str, output_vcf: str, policy: str = "exact", keep: str = "first") -> dict:
+    """Removes duplicate records of a sorted VCF. `policy` is "exact" (the same line), "site" (the same CHROM
+    and POS) or "alleles" (the same CHROM, POS, REF and ALT), `keep` is "first" or "qual" (the highest QUAL).
+    Returns the report: input, output and removed rows and the number of records with duplicates."""
+
+    if not os.path.exists(vcf_path):
+        logger_error("Input vcf not found")
+        sys.exit(1)
+
+    if os.path.exists(output_vcf):
+        logger_error("File output vcf already exists")
+        sys.exit(1)
+
+    vcf_path_encoded = vcf_path.encode("utf-8")
+    output_vcf_encoded = output_vcf.encode("utf-8")
+    policy_encoded = policy.encode("utf-8")
+    keep_encoded = keep.encode("utf-8")
+
+    report = Dedup(vcf_path_encoded, output_vcf_encoded, policy_encoded, keep_encoded)
+    return json.loads(report.decode("utf-8"))
 
 
 def index(vcf_path: str):
@@ -156,9 +542,33 @@ def main():
     parser.add_argument(
         "-sort", required=False, action="store_true", help="Sort VCF file."
     )
+    parser.add_argument(
+        "-check_sorted",
+        required=False,
+        action="store_true",
+        help="Check that VCF file is sorted.",
+    )
     parser.add_argument(
         "-index", required=False, action="store_true", help="Index VCF file."
     )
+    parser.add_argument(
+        "-subsample",
+        required=False,
+        action="store_true",
+        help="Randomly subsample or thin variants.",
+    )
+    parser.add_argument(
+        "-dedup",
+        required=False,
+        action="store_true",
+        help="Remove duplicate records of a sorted VCF file.",
+    )
+    parser.add_argument(
+        "-split",
+        required=False,
+        action="store_true",
+        help="Split VCF file by contig (or by sample) into the output directory.",
+    )
     parser.add_argument(
         "-save_vcf_as_zarr",
         required=False,
@@ -176,7 +586,93 @@ def main():
         "--include",
         required=False,
         type=str,
-        help="Expression. Example: QUAL>=30 or FILTER=='PASS' (string values must be under quotes).",
+        help="Expression in bcftools syntax. Example: QUAL>=30 && INFO/AF<0.01 or FILTER=\"PASS\" (string values must be under quotes).",
+    )
+    parser.add_argument(
+        "-e",
+        "--exclude",
+        required=False,
+        type=str,
+        default="",
+        help="Expression in bcftools syntax, records matching it are excluded.",
+    )
+    parser.add_argument(
+        "-s",
+        "--soft_filter",
+        required=False,
+        type=str,
+        default="",
+        help="Keep all records and write this name to the FILTER column of failing records.",
+    )
+    parser.add_argument(
+        "-r",
+        "--regions",
+        required=False,
+        type=str,
+        default="",
+        help="Comma separated regions (chr1:100-200,chr2), the index is used if present.",
+    )
+    parser.add_argument(
+        "-R",
+        "--regions_file",
+        required=False,
+        type=str,
+        default="",
+        help="BED file with target regions.",
+    )
+    parser.add_argument(
+        "-missing_policy",
+        "--missing_policy",
+        required=False,
+        type=str,
+        default="",
+        choices=["", "pass", "fail", "error"],
+        help="What to do with records where the expression reads a missing value.",
+    )
+    parser.add_argument(
+        "-samples",
+        "--samples",
+        required=False,
+        type=str,
+        default="",
+        help="Comma separated samples to keep, prefix the list with ^ to drop them.",
+    )
+    parser.add_argument(
+        "-samples_file",
+        "--samples_file",
+        required=False,
+        type=str,
+        default="",
+        help="File with samples to keep (one per line), prefix the path with ^ to drop them.",
+    )
+    parser.add_argument(
+        "-sites_only",
+        "--sites_only",
+        required=False,
+        action="store_true",
+        help="Drop FORMAT and sample columns.",
+    )
+    parser.add_argument(
+        "-recompute_tags",
+        "--recompute_tags",
+        required=False,
+        action="store_true",
+        help="Recompute AC, AN and AF from the genotypes of the kept samples.",
+    )
+    parser.add_argument(
+        "-lenient_header",
+        "--lenient_header",
+        required=False,
+        action="store_true",
+        help="Log a warning for INFO and FORMAT fields of the expression that are not defined in the header instead of an error.",
+    )
+    parser.add_argument(
+        "-outputs",
+        "--outputs",
+        required=False,
+        type=str,
+        default="",
+        help="JSON file with a list of outputs (name, include or exclude, output_vcf) to filter in a single pass.",
     )
     parser.add_argument(
         "-vcf", "--vcf", required=False, type=str, help="Input VCF file."
@@ -192,6 +688,50 @@ def main():
         default=".",
         help="File contains vcf paths which are located on separate lines in the file.",
     )
+    parser.add_argument(
+        "-m",
+        "--multiallelics",
+        required=False,
+        type=str,
+        default="both",
+        choices=["none", "snps", "indels", "both", "all"],
+        help="Records at the same position merged into multiallelic records by merging.",
+    )
+    parser.add_argument(
+        "-qual_rule",
+        "--qual_rule",
+        required=False,
+        type=str,
+        default="max",
+        choices=["max", "min", "avg"],
+        help="QUAL of the merged records.",
+    )
+    parser.add_argument(
+        "-filter_rule",
+        "--filter_rule",
+        required=False,
+        type=str,
+        default="union",
+        choices=["union", "pass"],
+        help="FILTER of the merged records: all filters or PASS if any record passes.",
+    )
+    parser.add_argument(
+        "-allele_counts",
+        "--allele_counts",
+        required=False,
+        type=str,
+        default="recompute",
+        choices=["recompute", "sum"],
+        help="AC, AN and AF of the merged records: recomputed from the genotypes or summed.",
+    )
+    parser.add_argument(
+        "-info_rules",
+        "--info_rules",
+        required=False,
+        type=str,
+        default="",
+        help="Rules of INFO keys for merging, for example DP:sum,DB:join.",
+    )
     parser.add_argument(
         "-o", "--output", required=False, type=str, help="Output VCF file."
     )
@@ -208,29 +748,188 @@ def main():
         "--chunk_size",
         type=int,
         required=False,
-        default=100_000,
-        help="Chunk size for sorting function.",
+        default=0,
+        help="Maximum number of records of a sorted chunk, 0 - limited by -memory only.",
+    )
+    parser.add_argument(
+        "-memory",
+        "--memory",
+        type=str,
+        required=False,
+        default="1G",
+        help="Memory budget for sorting, bytes or a size such as 512M or 4G.",
+    )
+    parser.add_argument(
+        "-temp_dir",
+        "--temp_dir",
+        type=str,
+        required=False,
+        default="",
+        help="Directory for the temporary files of sorting, the system temp dir by default.",
+    )
+    parser.add_argument(
+        "-temp_compression",
+        "--temp_compression",
+        type=int,
+        required=False,
+        default=1,
+        choices=range(10),
+        help="Gzip level of the temporary files of sorting, 0 - uncompressed.",
+    )
+    parser.add_argument(
+        "-by_end",
+        "--by_end",
+        required=False,
+        action="store_true",
+        help="Order the records with the same position, REF and ALT by END when sorting.",
+    )
+    parser.add_argument(
+        "-fraction",
+        "--fraction",
+        required=False,
+        type=float,
+        default=0.0,
+        help="Keep each variant with this probability.",
+    )
+    parser.add_argument(
+        "-count",
+        "--count",
+        required=False,
+        type=int,
+        default=0,
+        help="Keep exactly this number of variants.",
+    )
+    parser.add_argument(
+        "-seed",
+        "--seed",
+        required=False,
+        type=int,
+        default=0,
+        help="Random seed for subsampling.",
+    )
+    parser.add_argument(
+        "-thin",
+        "--thin",
+        required=False,
+        type=int,
+        default=0,
+        help="Keep at most one variant per this number of bp of each contig.",
+    )
+    parser.add_argument(
+        "-policy",
+        "--policy",
+        required=False,
+        type=str,
+        default="exact",
+        choices=["exact", "site", "alleles"],
+        help="Duplicates are records with the same line, CHROM/POS or CHROM/POS/REF/ALT.",
+    )
+    parser.add_argument(
+        "-keep",
+        "--keep",
+        required=False,
+        type=str,
+        default="first",
+        choices=["first", "qual"],
+        help="Keep the first duplicate or the one with the highest QUAL.",
+    )
+    parser.add_argument(
+        "-by_sample",
+        "--by_sample",
+        required=False,
+        action="store_true",
+        help="Split into single-sample VCF files.",
+    )
+    parser.add_argument(
+        "-bgzip",
+        "--bgzip",
+        required=False,
+        action="store_true",
+        help="Compress the outputs with bgzip.",
+    )
+    parser.add_argument(
+        "-max_open_files",
+        "--max_open_files",
+        required=False,
+        type=int,
+        default=0,
+        help="Maximum number of files open at once: outputs of Split (default 256), temporary files merged by Sort (default 128).",
+    )
+    parser.add_argument(
+        "-reference",
+        "--reference",
+        required=False,
+        type=str,
+        default="",
+        help="Reference .fai or .dict file with the contig order for sorting.",
     )
     parser.add_argument(
         "-show_progress", required=False, action="store_true", help="Show progress."
     )
+    parser.add_argument(
+        "-log_level",
+        "--log_level",
+        required=False,
+        type=str,
+        default="INFO",
+        help="Log level: DEBUG, INFO, WARNING, ERROR or OFF.",
+    )
+    parser.add_argument(
+        "-log_json", required=False, action="store_true", help="Write logs as JSON lines."
+    )
+    parser.add_argument(
+        "-log_file",
+        "--log_file",
+        required=False,
+        type=str,
+        default="stderr",
+        help="Log output: stderr, stdout or file path.",
+    )
 
     args = parser.parse_args()
 
     if len(sys.argv) > 1:
+        set_logger(level=args.log_level, json_format=args.log_json, output=args.log_file)
+
         if args.filter:
-            include: str = args.include
+            include: str = args.include or ""
+            exclude: str = args.exclude
+            soft_filter: str = args.soft_filter
             input_vcf: str = args.vcf
             output_vcf: str = args.output
             num_cpu: int = args.num_cpu
 
-            if include and input_vcf and output_vcf:
-                filter(
+            subsetting = args.samples or args.samples_file or args.sites_only or args.recompute_tags
+            if args.outputs and input_vcf:
+                with open(args.outputs, "r") as file:
+                    outputs = json.load(file)
+                report = filter_multi(
+                    outputs=outputs,
+                    input_vcf=input_vcf,
+                    num_cpu=num_cpu,
+                    regions=args.regions,
+                    regions_file=args.regions_file,
+                    missing_policy=args.missing_policy,
+                )
+                print(json.dumps(report, indent=2))
+            elif (include or exclude or subsetting) and input_vcf and output_vcf:
+                report = filter(
                     include=include,
                     input_vcf=input_vcf,
                     output_vcf=output_vcf,
                     num_cpu=num_cpu,
+                    exclude=exclude,
+                    soft_filter=soft_filter,
+                    regions=args.regions,
+                    regions_file=args.regions_file,
+                    missing_policy=args.missing_policy,
+                    samples=args.samples,
+                    samples_file=args.samples_file,
+                    sites_only=args.sites_only,
+                    recompute_tags=args.recompute_tags,
+                    lenient_header=args.lenient_header,
                 )
+                print(json.dumps(report, indent=2))
             else:
                 logger_error("Provide args")
         elif args.merge:
@@ -245,6 +944,11 @@ def main():
                     vcf2=vcf2,
                     output_vcf=output_vcf,
                     file_with_vcfs=file_with_vcfs,
+                    multiallelics=args.multiallelics,
+                    qual_rule=args.qual_rule,
+                    filter_rule=args.filter_rule,
+                    allele_counts=args.allele_counts,
+                    info_rules=args.info_rules,
                 )
             else:
                 logger_error("Provide args")
@@ -276,7 +980,71 @@ def main():
             chunk_size: int = args.chunk_size
 
             if vcf_path and output_vcf:
-                sort(vcf_path=vcf_path, output_vcf=output_vcf, chunk_size=chunk_size)
+                sort(
+                    vcf_path=vcf_path,
+                    output_vcf=output_vcf,
+                    chunk_size=chunk_size,
+                    reference=args.reference,
+                    memory=args.memory,
+                    num_cpu=args.num_cpu,
+                    temp_dir=args.temp_dir,
+                    temp_compression=args.temp_compression,
+                    max_open_files=args.max_open_files,
+                    by_end=args.by_end,
+                )
+            else:
+                logger_error("Provide args")
+        elif args.check_sorted:
+            vcf_path: str = args.vcf
+
+            if vcf_path:
+                report = check_sorted(vcf_path=vcf_path, reference=args.reference)
+                print(json.dumps(report, indent=2))
+            else:
+                logger_error("Provide args")
+        elif args.subsample:
+            vcf_path: str = args.vcf
+            output_vcf: str = args.output
+
+            if vcf_path and output_vcf and (args.fraction or args.count or args.thin):
+                report = subsample(
+                    vcf_path=vcf_path,
+                    output_vcf=output_vcf,
+                    fraction=args.fraction,
+                    count=args.count,
+                    seed=args.seed,
+                    thin_distance=args.thin,
+                )
+                print(json.dumps(report, indent=2))
+            else:
+                logger_error("Provide args")
+        elif args.dedup:
+            vcf_path: str = args.vcf
+            output_vcf: str = args.output
+
+            if vcf_path and output_vcf:
+                report = dedup(
+                    vcf_path=vcf_path,
+                    output_vcf=output_vcf,
+                    policy=args.policy,
+                    keep=args.keep,
+                )
+                print(json.dumps(report, indent=2))
+            else:
+                logger_error("Provide args")
+        elif args.split:
+            vcf_path: str = args.vcf
+            output_dir: str = args.output
+
+            if vcf_path and output_dir:
+                manifest = split(
+                    vcf_path=vcf_path,
+                    output_dir=output_dir,
+                    by_sample=args.by_sample,
+                    bgzip=args.bgzip,
+                    max_open_files=args.max_open_files,
+                )
+                print(json.dumps(manifest, indent=2))
             else:
                 logger_error("Provide args")
         elif args.index:
@@ -0,0 +1,48 @@
+import os
+
+from ..matrix_table_consumer import vcf_tools
+
+
+def read_records(path: str) -> list:
+    with open(path, "r") as file:
+        return [line.rstrip("\n").split("\t")[:6] for line in file if not line.startswith("#")]
+
+
+def test_dedup_exact() -> None:
+    vcf = "./data/dedup/test.vcf"
+    output_vcf = "./data/dedup/test_output.vcf"
+
+    report = vcf_tools.dedup(vcf_path=vcf, output_vcf=output_vcf)
+    records = read_records(output_vcf)
+    os.remove(output_vcf)
+
+    assert report["input_rows"] == 10
+    assert report["removed_rows"] == 2
+    assert report["output_rows"] == 8
+    assert len(records) == 8
+
+
+def test_dedup_alleles_qual() -> None:
+    vcf = "./data/dedup/test.vcf"
+    output_vcf = "./data/dedup/test_output.vcf"
+
+    report = vcf_tools.dedup(vcf_path=vcf, output_vcf=output_vcf, policy="alleles", keep="qual")
+    records = read_records(output_vcf)
+    os.remove(output_vcf)
+
+    assert report["removed_rows"] == 3
+    assert report["duplicate_groups"] == 3
+    assert ["chr1", "2", "rs2", "C", "T", "40"] in records
+    assert ["chr1", "1", ".", "C", "G", "50"] in records
+
+
+def test_dedup_site() -> None:
+    vcf = "./data/dedup/test.vcf"
+    output_vcf = "./data/dedup/test_output.vcf"
+
+    report = vcf_tools.dedup(vcf_path=vcf, output_vcf=output_vcf, policy="site")
+    records = read_records(output_vcf)
+    os.remove(output_vcf)
+
+    assert report["removed_rows"] == 5
+    assert [record[:2] for record in records] == [["chr1", "1"], ["chr1", "2"], ["chr1", "3"], ["chr2", "4"], ["chr2", "5"]]
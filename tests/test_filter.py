@@ -1,6 +1,10 @@
 import os
+import json
+
+import pytest
 
 from ..matrix_table_consumer import vcf_tools
+from ..matrix_table_consumer.matrix_table_consumer import MatrixTableConsumer
 
 
 def test_filter() -> None:
@@ -32,6 +36,7 @@ def test_filter() -> None:
         input_vcf=vcf,
         output_vcf=output_vcf,
         num_cpu=1,
+        lenient_header=True,
     )
 
     with (
@@ -49,6 +54,7 @@ def test_filter() -> None:
         input_vcf=vcf,
         output_vcf=output_vcf,
         num_cpu=1,
+        lenient_header=True,
     )
 
     with (
@@ -60,3 +66,773 @@ def test_filter() -> None:
 
         assert file1 == file2
     os.remove(output_vcf)
+
+
+def test_filter_exclude() -> None:
+    vcf = "./data/filter/test3.vcf"
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+    output_test_vcf = "./data/filter/test_filtered_1.vcf"
+
+    vcf_tools.filter(
+        exclude="FILTER!='PASS'",
+        input_vcf=vcf,
+        output_vcf=output_vcf,
+        num_cpu=1,
+    )
+
+    with (
+        open(output_test_vcf, "r") as output_test_file,
+        open(output_vcf, "r") as output_file,
+    ):
+        file1 = output_test_file.read()
+        file2 = output_file.read()
+
+        assert file1 == file2
+    os.remove(output_vcf)
+
+
+def test_filter_regions() -> None:
+    vcf = "./data/filter/test3.vcf"
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+    output_test_vcf = "./data/filter/test_filtered_regions.vcf"
+
+    vcf_tools.filter(
+        include="FILTER=='PASS'",
+        input_vcf=vcf,
+        output_vcf=output_vcf,
+        num_cpu=1,
+        regions="chr1:2-3,chr3",
+    )
+
+    with (
+        open(output_test_vcf, "r") as output_test_file,
+        open(output_vcf, "r") as output_file,
+    ):
+        file1 = output_test_file.read()
+        file2 = output_file.read()
+
+        assert file1 == file2
+    os.remove(output_vcf)
+
+
+def overlapping_records(vcf: str, regions: list[tuple[str, int, int]]) -> list[str]:
+    """Returns the records of the file overlapping any of the 1-based inclusive intervals"""
+
+    records = []
+    with open(vcf, "r") as vcf_file:
+        for line in vcf_file:
+            if line.startswith("#"):
+                continue
+            columns = line.split("\t")
+            pos = int(columns[1])
+            end = pos + len(columns[3]) - 1
+            for field in columns[7].split(";"):
+                if field.startswith("END="):
+                    end = int(field[4:])
+            if any(chrom == columns[0] and start <= end and pos <= stop for chrom, start, stop in regions):
+                records.append(line)
+    return records
+
+
+def test_filter_regions_index() -> None:
+    # test.vcf.gz is compressed in small BGZF blocks, so that records are split between blocks,
+    # and test.vcf.gz.tbi is its tabix index. test.vcf is the same file without compression
+    vcf = "./data/regions/test.vcf"
+    output_vcf = "./data/regions/test_output.vcf"
+
+    def filter_records(input_vcf: str, **regions) -> list[str]:
+        vcf_tools.filter(include="QUAL>0", input_vcf=input_vcf, output_vcf=output_vcf, **regions)
+        with open(output_vcf, "r") as output_file:
+            records = [line for line in output_file if not line.startswith("#")]
+        os.remove(output_vcf)
+        return records
+
+    cases = [
+        (
+            {"regions": "chr1:1-20000,chr2:150000-160000,chr3"},
+            [("chr1", 1, 20000), ("chr2", 150000, 160000), ("chr3", 1, 10**12)],
+        ),
+        # Borders of the 16 kb windows of the linear index
+        ({"regions": "chr1:16384-16400,chr1:32768"}, [("chr1", 16384, 16400), ("chr1", 32768, 32768)]),
+        (
+            {"regions_file": "./data/regions/targets.bed"},
+            [("chr1", 1, 5000), ("chr1", 40001, 41000), ("chr1", 120001, 180000), ("chr2", 100000, 100000), ("chr3", 65001, 70000)],
+        ),
+    ]
+    for regions, intervals in cases:
+        expected = overlapping_records(vcf, intervals)
+        assert len(expected) > 0
+        assert filter_records(vcf + ".gz", **regions) == expected
+        assert filter_records(vcf, **regions) == expected
+
+
+def test_filter_regions_merged() -> None:
+    vcf = "./data/regions/test.vcf"
+    output_vcf = "./data/regions/test_output.vcf"
+    log_file = "./data/regions/test_regions.log"
+
+    # overlaps.bed has overlapping and adjacent intervals of chr1 and the same intervals of chr2
+    # with a gap of one base, the regions add adjacent and contained intervals to both contigs
+    regions = {
+        "regions": "chr1:4001-5000,chr2:200000-,chr1:50000-60000,chr2:250000-260000",
+        "regions_file": "./data/regions/overlaps.bed",
+    }
+    intervals = [
+        ("chr1", 1000, 2000),
+        ("chr1", 1501, 3000),
+        ("chr1", 3001, 4000),
+        ("chr1", 4001, 5000),
+        ("chr1", 50000, 60000),
+        ("chr2", 1000, 2000),
+        ("chr2", 2002, 2500),
+        ("chr2", 200000, 10**12),
+    ]
+    expected = overlapping_records(vcf, intervals)
+    assert len(expected) > 0
+
+    for input_vcf in [vcf, vcf + ".gz"]:
+        vcf_tools.set_logger(level="DEBUG", json_format=True, output=log_file)
+        try:
+            vcf_tools.filter(include="QUAL>0", input_vcf=input_vcf, output_vcf=output_vcf, **regions)
+        finally:
+            vcf_tools.set_logger()
+
+        with open(log_file, "r") as file:
+            messages = [json.loads(line)["message"] for line in file]
+        os.remove(log_file)
+        with open(output_vcf, "r") as file:
+            records = [line for line in file if not line.startswith("#")]
+        os.remove(output_vcf)
+
+        assert "Regions: chr1:1000-5000,chr1:50000-60000,chr2:1000-2000,chr2:2002-2500,chr2:200000-" in messages
+        assert records == expected
+
+    consumer = MatrixTableConsumer(vcf_path=vcf + ".gz", reference_genome="GRCh37")
+    rows = consumer.collect(num_rows=len(expected) + 10, **regions)
+    assert [(row["CHROM"], row["POS"]) for row in rows] == [
+        (record.split("\t")[0], int(record.split("\t")[1])) for record in expected
+    ]
+
+
+def test_filter_report() -> None:
+    vcf = "./data/filter/test3.vcf"
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+
+    report = vcf_tools.filter(
+        include="FILTER=='PASS' || AC>=3",
+        input_vcf=vcf,
+        output_vcf=output_vcf,
+        num_cpu=2,
+    )
+    os.remove(output_vcf)
+
+    assert report["input_rows"] == 26
+    assert report["passed_rows"] == 17
+    assert report["failed_rows"] == 9
+    assert report["error_rows"] == 0
+    assert report["clauses"] == [
+        {"clause": "FILTER=='PASS'", "passed": 13},
+        {"clause": "AC>=3", "passed": 4},
+    ]
+
+
+def test_filter_unknown_field() -> None:
+    vcf = "./data/filter/test3.vcf"
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+
+    report = vcf_tools.filter(
+        include="AC>=1 && DPP>10",
+        input_vcf=vcf,
+        output_vcf=output_vcf,
+        num_cpu=1,
+    )
+
+    assert "INFO field DPP is not defined in the header" in report["error"]
+    assert not os.path.exists(output_vcf)
+
+
+def test_filter_undefined_field() -> None:
+    # AF is used in the records of test3.vcf but has no ##INFO line. Filter rejects such fields
+    # unless lenient_header is set, then they are only logged as warnings
+    vcf = "./data/filter/test3.vcf"
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+
+    report = vcf_tools.filter(include="AF>=0.03", input_vcf=vcf, output_vcf=output_vcf)
+
+    assert report["error"] == "Invalid expression: INFO field AF is not defined in the header, did you mean AC?"
+    assert not os.path.exists(output_vcf)
+
+    report = vcf_tools.filter(include="AF>=0.03", input_vcf=vcf, output_vcf=output_vcf, lenient_header=True)
+    os.remove(output_vcf)
+
+    assert "error" not in report
+    assert report["passed_rows"] == 1
+
+
+def test_filter_missing_policy() -> None:
+    vcf = "./data/filter/test3.vcf"
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+
+    report = vcf_tools.filter(
+        include="AF>=0.03",
+        input_vcf=vcf,
+        output_vcf=output_vcf,
+        num_cpu=1,
+        lenient_header=True,
+        missing_policy="pass",
+    )
+    os.remove(output_vcf)
+
+    assert report["passed_rows"] == 14
+
+    report = vcf_tools.filter(
+        include="AF>=0.03",
+        input_vcf=vcf,
+        output_vcf=output_vcf,
+        num_cpu=1,
+        lenient_header=True,
+        missing_policy="error",
+    )
+    os.remove(output_vcf)
+
+    assert report["passed_rows"] == 1
+    assert report["error_rows"] == 13
+    assert report["errors"][0]["message"] == "missing value of INFO/AF"
+
+
+def missing_policy_report(include: str, missing_policy: str) -> tuple[list[int], list[dict]]:
+    """Filters the test file with a missing value policy and returns the kept positions and the errors"""
+
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+    report = vcf_tools.filter(
+        include=include,
+        input_vcf="./data/filter/expressions.vcf",
+        output_vcf=output_vcf,
+        missing_policy=missing_policy,
+    )
+
+    with open(output_vcf, "r") as file:
+        positions = [int(line.split("\t")[1]) for line in file if not line.startswith("#")]
+    os.remove(output_vcf)
+    return positions, [{"message": error["message"], "lines": error["lines"]} for error in report["errors"]]
+
+
+def test_filter_missing_policy_any() -> None:
+    # At 200 only the first sample has DP (12), the policy decides any(FMT/DP>20) but not any(FMT/DP>10)
+    assert missing_policy_report("any(FMT/DP>10)", "pass") == ([100, 200, 300, 500, 600], [])
+    assert missing_policy_report("any(FMT/DP>10)", "fail") == ([100, 200, 300, 500, 600], [])
+    assert missing_policy_report("any(FMT/DP>10)", "error") == ([100, 200, 300, 500, 600], [])
+
+    assert missing_policy_report("any(FMT/DP>20)", "pass") == ([200, 300, 500], [])
+    assert missing_policy_report("any(FMT/DP>20)", "fail") == ([300, 500], [])
+    assert missing_policy_report("any(FMT/DP>20)", "error") == ([300, 500], [{"message": "missing value of FMT/DP", "lines": [18]}])
+
+    # A FORMAT condition without a quantifier is any()
+    assert missing_policy_report("FMT/DP>10", "error") == ([100, 200, 300, 500, 600], [])
+
+
+def test_filter_missing_policy_all() -> None:
+    # At 500 the first sample has no GQ and the other samples have 60 and 28
+    assert missing_policy_report("all(FMT/GQ>=20)", "pass") == ([200, 300, 500, 600], [])
+    assert missing_policy_report("all(FMT/GQ>=20)", "fail") == ([300, 600], [])
+    assert missing_policy_report("all(FMT/GQ>=20)", "error") == (
+        [300, 600],
+        [{"message": "missing value of FMT/GQ", "lines": [18, 21]}],
+    )
+
+    # At 200 and 500 another sample fails, the result does not depend on missing values
+    assert missing_policy_report("all(FMT/GQ>=30)", "pass") == ([300], [])
+    assert missing_policy_report("all(FMT/GQ>=30)", "error") == ([300], [])
+
+
+def test_filter_missing_policy_count() -> None:
+    # count() applies the policy to each sample, at 200 one sample passes and two are missing
+    assert missing_policy_report("count(FMT/DP>10)>=2", "pass") == ([100, 200, 300, 500, 600], [])
+    assert missing_policy_report("count(FMT/DP>10)>=2", "fail") == ([100, 300, 500, 600], [])
+    assert missing_policy_report("count(FMT/DP>10)>=2", "error") == (
+        [100, 300, 500, 600],
+        [{"message": "missing value of FMT/DP", "lines": [18]}],
+    )
+
+
+def test_filter_missing_policy_logic() -> None:
+    # DP is absent at 400, the record passes || and fails && without the policy
+    assert missing_policy_report('DP>=20 || GENE="KRAS"', "error") == ([100, 300, 400, 500], [])
+    assert missing_policy_report('DP>=20 && GENE!="KRAS"', "error") == ([100, 300, 500], [])
+    assert missing_policy_report('DP>=20 && GENE="KRAS"', "error") == ([], [{"message": "missing value of INFO/DP", "lines": [20]}])
+
+    # The negation of an unknown value is unknown
+    assert missing_policy_report("!(DP>=20)", "pass") == ([200, 400, 600], [])
+    assert missing_policy_report("!(DP>=20)", "fail") == ([200, 600], [])
+
+    # is_missing() is not affected by the policy
+    assert missing_policy_report("is_missing(DP)", "error") == ([400], [])
+
+
+def test_filter_samples() -> None:
+    vcf = "./data/filter/test3.vcf"
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+
+    report = vcf_tools.filter(
+        input_vcf=vcf,
+        output_vcf=output_vcf,
+        samples="tumor",
+        recompute_tags=True,
+    )
+
+    with open(output_vcf, "r") as file:
+        lines = [line.rstrip("\n") for line in file if not line.startswith("##")]
+    os.remove(output_vcf)
+
+    assert report["passed_rows"] == 26
+    assert lines[0].split("\t")[8:] == ["FORMAT", "tumor"]
+    assert lines[1] == "chr1\t1\t.\tC\tT\t1\tPASS\tAC=1;AN=2;AF=0.5\tGT\t0/1"
+
+
+def test_filter_sites_only() -> None:
+    vcf = "./data/filter/test3.vcf"
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+
+    vcf_tools.filter(
+        include="FILTER=='PASS'",
+        input_vcf=vcf,
+        output_vcf=output_vcf,
+        sites_only=True,
+    )
+
+    with open(output_vcf, "r") as file:
+        lines = [line.rstrip("\n") for line in file if not line.startswith("##")]
+    os.remove(output_vcf)
+
+    assert len(lines) == 14
+    assert all(len(line.split("\t")) == 8 for line in lines)
+
+
+def test_filter_multi() -> None:
+    vcf = "./data/filter/test3.vcf"
+    outputs = [
+        {
+            "name": "pass",
+            "include": "FILTER=='PASS'",
+            "output_vcf": "./data/filter/test_filtered_output_1.vcf",
+        },
+        {
+            "name": "af",
+            "include": "AF>=0.03",
+            "output_vcf": "./data/filter/test_filtered_output_2.vcf",
+            "lenient_header": True,
+        },
+    ]
+
+    report = vcf_tools.filter_multi(outputs=outputs, input_vcf=vcf, num_cpu=2)
+
+    with open(outputs[0]["output_vcf"], "r") as file:
+        output_1 = {line for line in file if not line.startswith("#")}
+    with open(outputs[1]["output_vcf"], "r") as file:
+        output_2 = {line for line in file if not line.startswith("#")}
+    with open("./data/filter/test_filtered_1.vcf", "r") as file:
+        expected_1 = {line for line in file if not line.startswith("#")}
+    with open("./data/filter/test_filtered_2.vcf", "r") as file:
+        expected_2 = {line for line in file if not line.startswith("#")}
+
+    for output in outputs:
+        os.remove(output["output_vcf"])
+
+    assert report["input_rows"] == 26
+    assert [output["name"] for output in report["outputs"]] == ["pass", "af"]
+    assert output_1 == expected_1
+    assert output_2 == expected_2
+
+
+def test_filter_log_error() -> None:
+    log_file = "./data/filter/test_filter.log"
+
+    # Python errors use the logger configured for Go
+    vcf_tools.set_logger(level="ERROR", json_format=True, output=log_file)
+    try:
+        with pytest.raises(SystemExit):
+            vcf_tools.filter(include="QUAL>0", input_vcf="./data/filter/missing.vcf", output_vcf="./data/filter/test_output.vcf")
+    finally:
+        vcf_tools.set_logger()
+
+    with open(log_file, "r") as file:
+        records = [json.loads(line) for line in file]
+    os.remove(log_file)
+
+    assert len(records) == 1
+    assert records[0]["level"] == "ERROR"
+    assert records[0]["message"] == "Input vcf not found"
+
+
+def filter_positions(include: str, vcf: str = "./data/filter/expressions.vcf", **options) -> list[int]:
+    """Filters the test file and returns the positions of the kept records"""
+
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+    report = vcf_tools.filter(include=include, input_vcf=vcf, output_vcf=output_vcf, **options)
+    assert "error" not in report
+
+    with open(output_vcf, "r") as file:
+        positions = [int(line.split("\t")[1]) for line in file if not line.startswith("#")]
+    os.remove(output_vcf)
+    return positions
+
+
+def filter_error(include: str, vcf: str = "./data/filter/expressions.vcf") -> str:
+    """Returns the error of an expression that is rejected before the file is read"""
+
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+    report = vcf_tools.filter(include=include, input_vcf=vcf, output_vcf=output_vcf)
+    assert not os.path.exists(output_vcf)
+    return report["error"]
+
+
+def test_filter_syntax_fields() -> None:
+    assert filter_positions("INFO/DP>=25") == [100, 300, 500]
+    assert filter_positions("DP>=25") == [100, 300, 500]
+    assert filter_positions("FMT/DP>=25") == [300]
+    assert filter_positions("FORMAT/GQ<10") == [400]
+    assert filter_positions('ID="rs1" || ID="rs3"') == [100, 300]
+    assert filter_positions("FILTER='PASS'") == [100, 300, 500, 600]
+    assert filter_positions("!(QUAL>30)") == [200, 400]
+
+
+def test_filter_syntax_type() -> None:
+    assert filter_positions('TYPE="snp"') == [100, 200, 500]
+    assert filter_positions('TYPE="indel"') == [300, 400]
+    assert filter_positions('TYPE="mnp"') == [600]
+    assert filter_positions('TYPE~"snp"') == [100, 200, 500]
+
+
+def test_filter_syntax_genotype() -> None:
+    assert filter_positions('GT="het"') == [100, 200, 300, 500, 600]
+    assert filter_positions('GT="hom"') == [100, 300, 400, 500, 600]
+    assert filter_positions('GT="AA"') == [100, 300, 500]
+    assert filter_positions('GT[0]="het"') == [100, 200, 500, 600]
+
+
+def test_filter_syntax_regex() -> None:
+    assert filter_positions('GENE~"^BRCA"') == [100, 300]
+    assert filter_positions('GENE!~"^BRCA"') == [200, 400, 500, 600]
+    assert filter_positions('FILTER~"Low"') == [200, 400]
+
+
+def test_filter_syntax_precedence() -> None:
+    # & requires both conditions in the same sample, && in any samples of the record
+    assert filter_positions("FMT/DP>=20 & FMT/GQ>=40") == [300, 500]
+    assert filter_positions("FMT/DP>=20 && FMT/GQ>=40") == [100, 300, 500]
+
+    # && binds tighter than ||, & binds tighter than |
+    assert filter_positions("QUAL<15 || QUAL>45 && DP>40") == [300, 400]
+    assert filter_positions("(QUAL<15 || QUAL>45) && DP>40") == [300]
+    assert filter_positions("FMT/DP<10 | FMT/GQ>50 & FMT/DP>25") == [100, 400]
+    assert filter_positions("(FMT/DP<10 | FMT/GQ>50) & FMT/DP>25") == []
+
+
+def test_filter_syntax_errors() -> None:
+    errors = {
+        "FMT/DP>": "unexpected end of expression, expected a value",
+        "QUAL>>1": "unexpected '>' at position 5, expected a value",
+        "(QUAL>1": "unexpected end of expression, expected ')'",
+        "QUAL>1)": "unexpected ')' at position 6, expected an operator",
+        "QUAL>1 $ 2": "unexpected character '$' at position 7",
+        "'PASS": "unterminated string at position 0",
+        "GENE~1": "regular expression at position 4 must be a string",
+        'GENE~"("': "invalid regular expression '(': error parsing regexp: missing closing ): `(`",
+        "foo(QUAL)": "unknown function 'foo' at position 0",
+        "POS[0]>1": "index at position 3 is supported only for INFO and FORMAT fields",
+        "DP[x]>1": "unexpected 'x' at position 3, expected a value index",
+    }
+    for include, message in errors.items():
+        assert filter_error(include) == f"failed to parse expression '{include}': {message}"
+
+
+def test_filter_function_has() -> None:
+    assert filter_positions("has(DB)") == [100, 500]
+    assert filter_positions("has(INFO/CSQ)") == [100, 300]
+    assert filter_positions("!has(DP)") == [400]
+    assert filter_positions("has(FMT/GQ)") == [100, 200, 300, 400, 500, 600]
+    assert filter_error("has(1)") == "failed to parse expression 'has(1)': has() argument 1 must be a field name"
+
+
+def test_filter_function_is_missing() -> None:
+    assert filter_positions("is_missing(DP)") == [400]
+    assert filter_positions("is_missing(FMT/DP)") == [200]
+    assert filter_positions("is_missing(FMT/GQ)") == [200, 500]
+
+
+def test_filter_function_contains() -> None:
+    assert filter_positions('contains(GENE, "RCA")') == [100, 300]
+    assert filter_positions('contains(CSQ, "splice")') == [100]
+
+
+def test_filter_function_match() -> None:
+    assert filter_positions('match(GENE, "^BR")') == [100, 300]
+    assert filter_positions('match(GENE, "BRCA[12]$")') == [100, 300]
+
+    # Literal patterns are compiled before the file is read
+    assert filter_error('match(GENE, "(")') == (
+        "Invalid expression: match() argument 2: invalid regular expression '(': error parsing regexp: missing closing ): `(`"
+    )
+
+
+def test_filter_function_strlen() -> None:
+    assert filter_positions("strlen(GENE)==3") == [600]
+    assert filter_positions("strlen(REF)>1") == [300, 600]
+    assert filter_error("strlen(QUAL)>1") == (
+        "failed to parse expression 'strlen(QUAL)>1': strlen() argument 1 must be string, got number"
+    )
+
+
+def test_filter_function_abs() -> None:
+    assert filter_positions("abs(QUAL-40)<=10") == [100, 500, 600]
+    assert filter_error("abs(GENE)>1") == "Invalid expression: abs() argument 1 must be number, got string"
+
+
+def test_filter_function_min() -> None:
+    assert filter_positions("min(FMT/DP)<10") == [100, 400]
+    assert filter_positions("min(AF)<0.1") == [200]
+    assert filter_positions("min(FMT/GQ)>=30") == [300]
+
+
+def test_filter_function_max() -> None:
+    assert filter_positions("max(FMT/DP)>=25") == [300]
+    assert filter_positions("max(AF)>=0.3") == [300, 500]
+    assert filter_positions("MAX(FMT/GQ)>=50") == [300, 500]
+
+
+def test_filter_function_sum() -> None:
+    assert filter_positions("sum(FMT/DP)>=60") == [300]
+    assert filter_positions("sum(RC)>=30") == [100, 300]
+
+
+def test_filter_function_avg() -> None:
+    assert filter_positions("avg(FMT/DP)>15") == [300, 500]
+    assert filter_positions("avg(AF)>0.2") == [300, 500, 600]
+    assert filter_positions("avg(RC)>=20") == [300]
+
+
+def test_filter_function_is_snp() -> None:
+    assert filter_positions("is_snp()") == [100, 200, 500]
+    assert filter_error("is_snp(1)") == "failed to parse expression 'is_snp(1)': is_snp() expects 0 arguments, got 1"
+
+
+def test_filter_function_is_indel() -> None:
+    assert filter_positions("is_indel()") == [300, 400]
+
+
+def test_filter_function_is_multiallelic() -> None:
+    assert filter_positions("is_multiallelic()") == [200]
+
+
+def test_filter_function_in() -> None:
+    assert filter_positions('in(GENE, ["TP53", "MYC"])') == [200, 600]
+    assert filter_positions("in(DP, [8, 12])") == [200, 600]
+
+
+def test_filter_quantifiers() -> None:
+    assert filter_positions("any(FMT/DP>10)") == [100, 200, 300, 500, 600]
+    assert filter_positions("all(FMT/DP>10)") == [300, 500, 600]
+    assert filter_positions("count(FMT/DP>10)>=2") == [100, 300, 500, 600]
+    assert filter_positions("N_PASS(FMT/DP>10)>=2") == [100, 300, 500, 600]
+    assert filter_positions('count(GT="alt")>=2') == [100, 200, 300, 500]
+    assert filter_positions('F_PASS(GT="het")>0.5') == [200, 300, 500]
+
+    # INFO arrays are quantified over their values
+    assert filter_positions("all(AF>=0.1)") == [100, 300, 500, 600]
+    assert filter_positions("any(AF<0.1)") == [200]
+    assert filter_positions("count(AF>0)==2") == [200]
+
+
+def test_filter_quantifiers_missing() -> None:
+    # A missing sample value fails the comparison, so all() is false and count() skips it
+    assert filter_positions("all(FMT/GQ>=20)") == [300, 600]
+    assert filter_positions("any(FMT/GQ<20)") == [100, 400]
+    assert filter_positions('count(FMT/GQ=".")==1') == [200, 500]
+    assert filter_positions("count(FMT/DP>=0)==1") == [200]
+
+    # The negation of a false comparison is true
+    assert filter_positions("any(!(FMT/DP>10))") == [100, 200, 400]
+
+    # AF is absent at 400
+    assert filter_positions("all(AF>=0)") == [100, 200, 300, 500, 600]
+
+
+def test_filter_quantifiers_nested() -> None:
+    assert filter_positions("all(FMT/DP>10 & FMT/GQ>20)") == [300, 600]
+    assert filter_positions("any(FMT/DP>20 & FMT/GQ>40)") == [300, 500]
+    assert filter_positions('count(FMT/DP>10 | GT="AA")==3') == [100, 300, 500, 600]
+    assert filter_positions("all(FMT/DP>=DP/3)") == [300, 500, 600]
+    assert filter_positions("any(FMT/DP>DP)") == [200, 600]
+    assert filter_positions("count(FMT/DP*2>DP)>=2") == [500, 600]
+    assert filter_positions("any(any(FMT/DP>10))") == [100, 200, 300, 500, 600]
+    assert filter_positions("count(FMT/DP>10)>=2 && all(FMT/GQ>=20)") == [300, 600]
+
+
+def test_filter_quantifiers_no_samples() -> None:
+    # Without samples any() is false, all() is true and count() is 0
+    vcf = "./data/filter/expressions_sites.vcf"
+    assert filter_positions("any(FMT/DP>10)", vcf=vcf) == []
+    assert filter_positions("all(FMT/DP>10)", vcf=vcf) == [100, 200, 300, 400, 500, 600]
+    assert filter_positions("count(FMT/DP>10)==0", vcf=vcf) == [100, 200, 300, 400, 500, 600]
+    assert filter_positions("F_PASS(FMT/DP>10)>=0", vcf=vcf) == []
+    assert filter_positions("FMT/DP>10", vcf=vcf) == []
+
+
+def soft_filtered(**options) -> tuple[list[str], list[str]]:
+    """Filters the test file with a soft filter and returns the ##FILTER lines and the FILTER column of the records"""
+
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+    report = vcf_tools.filter(input_vcf="./data/filter/expressions.vcf", output_vcf=output_vcf, **options)
+    assert "error" not in report
+
+    with open(output_vcf, "r") as file:
+        lines = [line.rstrip("\n") for line in file]
+    os.remove(output_vcf)
+
+    filter_lines = [line for line in lines if line.startswith("##FILTER=")]
+    filters = [line.split("\t")[6] for line in lines if not line.startswith("#")]
+    return filter_lines, filters
+
+
+def test_filter_soft_filter() -> None:
+    filter_lines, filters = soft_filtered(include="DP>=20", soft_filter="LowDP", num_cpu=2)
+
+    # All records are kept, PASS is replaced and other filters are appended
+    assert filters == ["PASS", "LowQual;LowDP", "PASS", "LowQual;LowDP", "PASS", "LowDP"]
+    assert filter_lines == [
+        '##FILTER=<ID=PASS,Description="All filters passed">',
+        '##FILTER=<ID=LowQual,Description="Low quality">',
+        '##FILTER=<ID=LowDP,Description="Set if not true: DP>=20">',
+    ]
+
+
+def test_filter_soft_filter_exclude() -> None:
+    filter_lines, filters = soft_filtered(exclude="QUAL<30", soft_filter="LowQual")
+
+    # LowQual is already in the header and in some records, it is neither written nor appended twice
+    assert filters == ["PASS", "LowQual", "PASS", "LowQual", "PASS", "PASS"]
+    assert filter_lines == [
+        '##FILTER=<ID=PASS,Description="All filters passed">',
+        '##FILTER=<ID=LowQual,Description="Low quality">',
+    ]
+
+
+def test_filter_soft_filter_multi() -> None:
+    outputs = [
+        {
+            "name": "dp",
+            "include": "DP>=20",
+            "soft_filter": "LowDP",
+            "output_vcf": "./data/filter/test_filtered_output_1.vcf",
+        },
+        {
+            "name": "gq",
+            "include": "all(FMT/GQ>=20)",
+            "soft_filter": "LowGQ",
+            "output_vcf": "./data/filter/test_filtered_output_2.vcf",
+        },
+    ]
+
+    report = vcf_tools.filter_multi(outputs=outputs, input_vcf="./data/filter/expressions.vcf", num_cpu=2)
+    assert "error" not in report
+
+    for output, name, expected in [
+        (outputs[0], "LowDP", {100: "PASS", 200: "LowQual;LowDP", 300: "PASS", 400: "LowQual;LowDP", 500: "PASS", 600: "LowDP"}),
+        (outputs[1], "LowGQ", {100: "LowGQ", 200: "LowQual;LowGQ", 300: "PASS", 400: "LowQual;LowGQ", 500: "LowGQ", 600: "PASS"}),
+    ]:
+        with open(output["output_vcf"], "r") as file:
+            lines = [line.rstrip("\n") for line in file]
+        os.remove(output["output_vcf"])
+
+        assert sum(line.startswith(f"##FILTER=<ID={name},") for line in lines) == 1
+        records = [line.split("\t") for line in lines if not line.startswith("#")]
+        assert {int(record[1]): record[6] for record in records} == expected
+
+
+def test_filter_index_info() -> None:
+    # AF is Number=A, AF[1] is the frequency of the second ALT allele
+    assert filter_positions("AF[1]>0.01") == [200]
+    assert filter_positions("AF[0]>=0.25") == [300, 500, 600]
+    # RC is Number=R, RC[0] is the count of REF
+    assert filter_positions("RC[0]>=20") == [100, 300]
+    assert filter_positions("RC[2]>=2") == [200]
+    assert filter_positions('GENE[0]="TP53"') == [200]
+
+
+def test_filter_index_format() -> None:
+    # FMT/AD[0] is the AD list of the first sample
+    assert filter_positions("FMT/AD[0]>=10") == [100, 300]
+    assert filter_positions("sum(FMT/AD[1])>=30") == [300]
+    assert filter_positions("contains(FMT/AD[0], 25)") == [300]
+    assert filter_positions('FMT/AD[2]="."') == [200]
+
+
+def test_filter_index_out_of_range() -> None:
+    # Values beyond the end of an array are missing
+    assert filter_positions("AF[5]>0") == []
+    assert filter_positions('AF[1]="."') == [100, 300, 400, 500, 600]
+    assert filter_positions('GENE[1]="."') == [100, 200, 300, 400, 500, 600]
+
+    output_vcf = "./data/filter/test_filtered_output.vcf"
+    report = vcf_tools.filter(
+        include="AF[5]>0",
+        input_vcf="./data/filter/expressions.vcf",
+        output_vcf=output_vcf,
+        missing_policy="error",
+    )
+    os.remove(output_vcf)
+
+    assert report["error_rows"] == 6
+    assert report["errors"][0]["message"] == "missing value of INFO/AF"
+
+    # Samples beyond the last one are an error of each record
+    report = vcf_tools.filter(include="FMT/DP[5]>1", input_vcf="./data/filter/expressions.vcf", output_vcf=output_vcf)
+    os.remove(output_vcf)
+
+    assert report["error_rows"] == 6
+    assert report["errors"][0]["message"] == "sample index 5 is out of range, the record has 3 samples"
+
+
+def test_filter_typed_arrays() -> None:
+    # A condition on a list is true if it is true for any value, all() requires every value
+    assert filter_positions("AF>=0.2") == [200, 300, 500, 600]
+    assert filter_positions("all(AF>=0.2)") == [300, 500, 600]
+
+    # CSQ is a list of strings
+    assert filter_positions('CSQ="splice"') == [100]
+    assert filter_positions('CSQ~"^frame"') == [300]
+
+    # FMT/AD is Number=R, a list for each sample
+    assert filter_positions("any(FMT/AD>20)") == [300, 500]
+    assert filter_positions("all(FMT/AD>=5)") == [100, 300, 400, 500, 600]
+    assert filter_positions("max(FMT/AD)>=25") == [300]
+    assert filter_positions("sum(FMT/AD)>=60") == [300]
+
+
+def test_filter_multi_large() -> None:
+    # Every record passes all outputs, so there are more results than records. The results are
+    # written while the input is read, so the workers never wait for the reader
+    vcf = "./data/filter/test_large.vcf"
+    records = 300000
+    with open(vcf, "w") as file:
+        file.write("##fileformat=VCFv4.2\n")
+        file.write("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n")
+        for pos in range(1, records + 1):
+            file.write(f"chr1\t{pos}\t.\tA\tC\t{pos % 100}\tPASS\t.\n")
+
+    outputs = [
+        {"name": f"output_{i}", "include": "QUAL>=0", "output_vcf": f"./data/filter/test_filtered_output_{i}.vcf"}
+        for i in range(3)
+    ]
+    report = vcf_tools.filter_multi(outputs=outputs, input_vcf=vcf, num_cpu=2)
+    os.remove(vcf)
+
+    counts = []
+    for output in outputs:
+        with open(output["output_vcf"], "r") as file:
+            counts.append(sum(1 for line in file if not line.startswith("#")))
+        os.remove(output["output_vcf"])
+
+    assert report["input_rows"] == records
+    assert [output["passed_rows"] for output in report["outputs"]] == [records] * 3
+    assert counts == [records] * 3
@@ -1,3 +1,4 @@
+import json
 import os
 
 from ..matrix_table_consumer import vcf_tools
@@ -60,3 +61,187 @@ def test_merge() -> None:
 
         assert output_test_file_set == output_file_set
     os.remove(output_vcf)
+
+
+def test_merge_order() -> None:
+    # The inputs are merged in lockstep, the output must be in the order of the sorted inputs
+    output_vcf = "./data/merge/test_output_merged.vcf"
+    output_test_vcf = "./data/merge/test_merged.vcf"
+
+    for inputs in [
+        {"vcf1": "./data/merge/test1.vcf", "vcf2": "./data/merge/test2.vcf"},
+        {"file_with_vcfs": "./data/merge/vcfs.txt"},
+    ]:
+        vcf_tools.merge(output_vcf=output_vcf, **inputs)
+
+        with (
+            open(output_test_vcf, "r") as output_test_file,
+            open(output_vcf, "r") as output_file,
+        ):
+            assert output_test_file.read() == output_file.read()
+        os.remove(output_vcf)
+
+
+def test_merge_unsorted() -> None:
+    # chr1:2 follows chr1:16 in the unsorted input, the partial output is removed
+    output_vcf = "./data/merge/test_output_merged.vcf"
+    log_file = "./data/merge/test_merge.log"
+
+    vcf_tools.set_logger(level="ERROR", json_format=True, output=log_file)
+    try:
+        vcf_tools.merge(vcf1="./data/merge/test1.vcf", vcf2="./data/sort/test.vcf", output_vcf=output_vcf)
+    finally:
+        vcf_tools.set_logger()
+
+    with open(log_file, "r") as file:
+        messages = [json.loads(line)["message"] for line in file]
+    os.remove(log_file)
+
+    assert not os.path.exists(output_vcf)
+    assert any(
+        "./data/sort/test.vcf is not sorted: chr1:2 after chr1:16, sort it with Sort before merging" in message
+        for message in messages
+    )
+
+
+def test_merge_multiallelics() -> None:
+    vcf1 = "./data/merge/alleles1.vcf"
+    vcf2 = "./data/merge/alleles2.vcf"
+    output_vcf = "./data/merge/test_output_merged.vcf"
+
+    def merged_records(multiallelics: str) -> list[list[str]]:
+        vcf_tools.merge(
+            vcf1=vcf1,
+            vcf2=vcf2,
+            output_vcf=output_vcf,
+            multiallelics=multiallelics,
+        )
+        with open(output_vcf, "r") as output_file:
+            records = [
+                [parts[1], parts[3], parts[4], *parts[9:]]
+                for line in output_file
+                if not line.startswith("#")
+                for parts in [line.rstrip("\n").split("\t")]
+            ]
+        os.remove(output_vcf)
+        return records
+
+    # A SNP and an indel at the same position are never collapsed
+    assert merged_records("none") == [
+        ["10", "A", "C", "0/1", "./."],
+        ["10", "A", "G", "./.", "1/1"],
+        ["20", "A", "G", "1/1", "./."],
+        ["20", "AT", "A", "./.", "0/1"],
+        ["30", "C", "T", "0|1", "./."],
+        ["30", "CTT", "C", "./.", "0/1"],
+    ]
+
+    # SNPs are merged into a multiallelic record, the GT of sample2 points to the second ALT
+    assert merged_records("both")[0] == ["10", "A", "C,G", "0/1", "2/2"]
+
+    # REF is extended to the longest REF of the position
+    assert merged_records("all") == [
+        ["10", "A", "C,G", "0/1", "2/2"],
+        ["20", "AT", "GT,A", "1/1", "0/2"],
+        ["30", "CTT", "TTT,C", "0|1", "0/2"],
+    ]
+
+
+def test_merge_multiallelics_format() -> None:
+    # AD (Number=R) and PL (Number=G) are moved to the merged alleles, the alleles of other records are "."
+    output_vcf = "./data/merge/test_output_merged.vcf"
+
+    def merged_samples(multiallelics: str) -> list[list[str]]:
+        vcf_tools.merge(
+            vcf1="./data/merge/alleles_format1.vcf",
+            vcf2="./data/merge/alleles_format2.vcf",
+            output_vcf=output_vcf,
+            multiallelics=multiallelics,
+        )
+        with open(output_vcf, "r") as output_file:
+            records = [line.rstrip("\n").split("\t") for line in output_file if not line.startswith("#")]
+        os.remove(output_vcf)
+        return [[record[1], record[4], record[8], *record[9:]] for record in records]
+
+    assert merged_samples("none")[:2] == [
+        ["10", "C", "GT:AD:PL", "0/1:10,5:40,0,50", "./.:.:."],
+        ["10", "G", "GT:AD:PL", "./.:.:.", "1/1:0,9:80,25,0"],
+    ]
+
+    # PL of A,C,G is ordered A/A, A/C, C/C, A/G, C/G, G/G
+    assert merged_samples("all") == [
+        ["10", "C,G", "GT:AD:PL", "0/1:10,5,.:40,0,50,.,.,.", "2/2:0,.,9:80,.,.,25,.,0"],
+        ["20", "GT,A", "GT:AD:PL", "1/1:0,12,.:90,30,0,.,.,.", "0/2:6,.,6:35,.,.,0,.,40"],
+        ["30", "TTT,C", "GT:AD:PL", "0|1:8,7,.:30,0,45,.,.,.", "0/2:5,.,4:20,.,.,0,.,60"],
+    ]
+
+
+def test_merge_same_input_position() -> None:
+    # same_pos1.vcf has A>C and A>G of sample1 at chr1:10. Records of one input are never merged,
+    # A>G of sample2 is merged with the A>G record of sample1
+    output_vcf = "./data/merge/test_output_merged.vcf"
+
+    for multiallelics in ["none", "both", "all"]:
+        vcf_tools.merge(
+            vcf1="./data/merge/same_pos1.vcf",
+            vcf2="./data/merge/same_pos2.vcf",
+            output_vcf=output_vcf,
+            multiallelics=multiallelics,
+        )
+        with open(output_vcf, "r") as output_file:
+            records = [line.rstrip("\n").split("\t") for line in output_file if not line.startswith("#")]
+        os.remove(output_vcf)
+
+        assert [[record[1], record[3], record[4], record[7], *record[9:]] for record in records] == [
+            ["10", "A", "C", "AC=1;AN=2", "0/1", "./."],
+            ["10", "A", "G", "AC=2;AN=4", "0/0", "1/1"],
+            ["20", "G", "T", "AC=3;AN=4", "1/1", "0/1"],
+        ]
+
+
+def test_merge_rules() -> None:
+    vcf1 = "./data/merge/rules1.vcf"
+    vcf2 = "./data/merge/rules2.vcf"
+    output_vcf = "./data/merge/test_output_merged.vcf"
+
+    def merged_record(**rules) -> list[str]:
+        vcf_tools.merge(vcf1=vcf1, vcf2=vcf2, output_vcf=output_vcf, **rules)
+        with open(output_vcf, "r") as output_file:
+            records = [line.rstrip("\n").split("\t") for line in output_file if not line.startswith("#")]
+        os.remove(output_vcf)
+        assert len(records) == 1
+        return records[0]
+
+    # IDs are joined, QUAL is the maximum, FILTER is the union and AC/AN/AF are counted from the genotypes.
+    # MQ (Number=A) is combined per allele, CSQ (Number=.) is joined and DP (Number=1) is the first value
+    record = merged_record()
+    assert record[2:7] == ["rs1;rs2", "A", "C,G", "30", "q10"]
+    assert record[7] == "AC=2,1;AN=4;AF=0.5,0.25;DP=10;MQ=60,50;CSQ=x,y;DB"
+    assert record[9:] == ["0/1", "2/1"]
+
+    record = merged_record(
+        qual_rule="min",
+        filter_rule="pass",
+        allele_counts="sum",
+        info_rules="DP:sum,CSQ:first",
+    )
+    assert record[5:7] == ["30", "PASS"]
+    assert record[7] == "AC=6,1;AN=12;AF=0.5,0.0833333;DP=15;MQ=60,50;CSQ=x;DB"
+
+
+def test_merge_format() -> None:
+    vcf1 = "./data/merge/format1.vcf"
+    vcf2 = "./data/merge/format2.vcf"
+    output_vcf = "./data/merge/test_output_merged.vcf"
+
+    vcf_tools.merge(vcf1=vcf1, vcf2=vcf2, output_vcf=output_vcf)
+    with open(output_vcf, "r") as output_file:
+        records = [line.rstrip("\n").split("\t") for line in output_file if not line.startswith("#")]
+    os.remove(output_vcf)
+
+    # The FORMAT keys of both files are merged (GT first) and missing subfields are filled with "."
+    assert [record[8:] for record in records] == [
+        ["GT:AD:DP:GQ:PL", "0/1:5,7:12:.:.", "0/0:.:8:20:0,20,200"],
+        ["GT:AD:DP", "1/1:0,9:9", "./.:.:."],
+        ["GT:DP", "./.:.", "0/1:6"],
+    ]
@@ -0,0 +1,30 @@
+import os
+
+from ..matrix_table_consumer import vcf_tools
+
+
+def test_progress_callback() -> None:
+    vcf = "./data/regions/test.vcf"
+    output_vcf = "./data/regions/test_output.vcf"
+
+    calls = []
+    vcf_tools.set_progress_callback(lambda *args: calls.append(args))
+    try:
+        report = vcf_tools.filter(include="QUAL>0", input_vcf=vcf, output_vcf=output_vcf)
+    finally:
+        vcf_tools.set_progress_callback(None)
+    os.remove(output_vcf)
+
+    assert len(calls) > 0
+    assert all(operation == "filter" for operation, _, _, _ in calls)
+
+    records = [call[1] for call in calls]
+    bytes_processed = [call[2] for call in calls]
+    assert records == sorted(records)
+    assert bytes_processed == sorted(bytes_processed)
+
+    # The final call reports all records and the whole file
+    _, last_records, last_bytes, total = calls[-1]
+    assert last_records == report["input_rows"] == 2100
+    assert total == os.path.getsize(vcf)
+    assert last_bytes == total
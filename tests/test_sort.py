@@ -1,4 +1,6 @@
+import json
 import os
+import random
 
 from ..matrix_table_consumer import vcf_tools
 
@@ -41,3 +43,196 @@ def test_sort() -> None:
         assert output_test_file_text == output_file_text
 
     os.remove(output_vcf)
+
+
+def test_sort_reference_order() -> None:
+    vcf = "./data/sort/test.vcf"
+    output_vcf = "./data/sort/test_output_sorted.vcf"
+
+    vcf_tools.sort(
+        vcf_path=vcf,
+        output_vcf=output_vcf,
+        chunk_size=100,
+        reference="./data/sort/reverse.fa.fai",
+    )
+
+    with open(output_vcf, "r") as output_file:
+        contigs = []
+        for line in output_file:
+            contig = line.split("\t")[0]
+            if not line.startswith("#") and contig not in contigs:
+                contigs.append(contig)
+
+    os.remove(output_vcf)
+
+    assert contigs == ["chr6", "chr5", "chr4", "chr3", "chr2", "chr1"]
+
+
+def sort_logged(**options) -> list[str]:
+    """Sorts with debug logs in a file and returns the log messages"""
+
+    log_file = "./data/sort/test_sort.log"
+    vcf_tools.set_logger(level="DEBUG", json_format=True, output=log_file)
+    try:
+        vcf_tools.sort(**options)
+    finally:
+        vcf_tools.set_logger()
+
+    with open(log_file, "r") as file:
+        messages = [json.loads(line)["message"] for line in file]
+    os.remove(log_file)
+    return messages
+
+
+def test_sort_memory() -> None:
+    vcf = "./data/sort/test.vcf"
+    output_vcf = "./data/sort/test_output_sorted.vcf"
+    output_test_vcf = "./data/sort/test_sorted.vcf"
+
+    # A budget of a few records per chunk, the chunks are written to temp files by two workers
+    messages = sort_logged(
+        vcf_path=vcf,
+        output_vcf=output_vcf,
+        memory="2K",
+        num_cpu=2,
+        temp_dir="./data/sort",
+        temp_compression=0,
+    )
+
+    with (
+        open(output_test_vcf, "r") as output_test_file,
+        open(output_vcf, "r") as output_file,
+    ):
+        assert output_test_file.read() == output_file.read()
+
+    os.remove(output_vcf)
+    assert sum(message.startswith("Saved chunk") for message in messages) >= 5
+    assert not any(name.startswith("vcf_sort_") for name in os.listdir("./data/sort"))
+
+
+def test_sort_memory_merge_levels() -> None:
+    vcf = "./data/sort/test_large.vcf"
+    output_vcf = "./data/sort/test_output_sorted.vcf"
+    output_test_vcf = "./data/sort/test_output_in_memory.vcf"
+
+    random.seed(1)
+    with open(vcf, "w") as file:
+        file.write("##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n")
+        for i in range(1000):
+            chrom = random.choice(["chr1", "chr2", "chr10", "chrX"])
+            file.write(f"{chrom}\t{random.randint(1, 500)}\trs{i}\tA\t{random.choice('CGT')}\t.\tPASS\t.\n")
+
+    # The whole file is sorted in memory
+    vcf_tools.sort(vcf_path=vcf, output_vcf=output_test_vcf)
+
+    # One record per chunk: more than 128 temp files are merged in groups first
+    messages = sort_logged(
+        vcf_path=vcf,
+        output_vcf=output_vcf,
+        memory=300,
+        num_cpu=2,
+        temp_dir="./data/sort",
+        temp_compression=1,
+    )
+
+    with (
+        open(output_test_vcf, "r") as output_test_file,
+        open(output_vcf, "r") as output_file,
+    ):
+        assert output_test_file.read() == output_file.read()
+
+    report = vcf_tools.check_sorted(output_vcf)
+
+    # A lower limit of open files needs more rounds
+    limited_messages = sort_logged(
+        vcf_path=vcf,
+        output_vcf=output_vcf,
+        memory=300,
+        num_cpu=2,
+        temp_dir="./data/sort",
+        max_open_files=10,
+    )
+
+    with (
+        open(output_test_vcf, "r") as output_test_file,
+        open(output_vcf, "r") as output_file,
+    ):
+        assert output_test_file.read() == output_file.read()
+
+    for path in [vcf, output_vcf, output_test_vcf]:
+        os.remove(path)
+
+    assert report["sorted"]
+    assert sum(message.startswith("Saved chunk") for message in messages) == 1000
+    assert "Merged 1000 chunks into 8" in messages
+    assert "Merged 1000 chunks into 100" in limited_messages
+    assert "Merged 100 chunks into 10" in limited_messages
+    assert not any(name.startswith("vcf_sort_") for name in os.listdir("./data/sort"))
+
+
+def test_sort_ties() -> None:
+    vcf = "./data/sort/ties.vcf"
+    output_vcf = "./data/sort/test_output_sorted.vcf"
+    output_test_vcf = "./data/sort/ties_sorted.vcf"
+
+    for chunk_size in (0, 1, 3):
+        vcf_tools.sort(
+            vcf_path=vcf,
+            output_vcf=output_vcf,
+            chunk_size=chunk_size,
+            num_cpu=2,
+        )
+
+        with (
+            open(output_test_vcf, "r") as output_test_file,
+            open(output_vcf, "r") as output_file,
+        ):
+            assert output_test_file.read() == output_file.read()
+
+        os.remove(output_vcf)
+
+    # Records with the same REF and ALT keep the input order unless they are ordered by END
+    vcf_tools.sort(vcf_path=vcf, output_vcf=output_vcf, chunk_size=3, num_cpu=2, by_end=True)
+
+    with (
+        open("./data/sort/ties_sorted_by_end.vcf", "r") as output_test_file,
+        open(output_vcf, "r") as output_file,
+    ):
+        assert output_test_file.read() == output_file.read()
+
+    os.remove(output_vcf)
+
+
+def test_check_sorted() -> None:
+    report = vcf_tools.check_sorted(vcf_path="./data/sort/test_sorted.vcf")
+    assert report == {"sorted": True, "interleaved_contigs": False}
+
+    report = vcf_tools.check_sorted(vcf_path="./data/sort/test.vcf")
+    assert not report["sorted"]
+    assert report["first_unsorted"] == {"line": 27, "previous": "chr1:16", "record": "chr1:2"}
+
+    report = vcf_tools.check_sorted(
+        vcf_path="./data/sort/test_sorted.vcf",
+        reference="./data/sort/reverse.fa.fai",
+    )
+    assert not report["sorted"]
+    assert not report["interleaved_contigs"]
+    assert report["first_unsorted"]["record"] == "chr2:4"
+
+    # Only the contig and position decide whether the file is sorted
+    report = vcf_tools.check_sorted(vcf_path="./data/sort/ties_sorted.vcf")
+    assert report == {"sorted": True, "interleaved_contigs": False}
+
+    report = vcf_tools.check_sorted(vcf_path="./data/sort/ties.vcf")
+    assert not report["sorted"]
+    assert report["first_unsorted"] == {"line": 6, "previous": "chr1:20", "record": "chr1:10"}
+
+    # Records at the same position that are not in the order of Sort are a note
+    report = vcf_tools.check_sorted(vcf_path="./data/sort/ties_position_sorted.vcf")
+    assert report["sorted"]
+    assert "first_unsorted" not in report
+    assert report["tie_order"] == {
+        "line": 7,
+        "previous": "chr1:10 A>C,G",
+        "record": "chr1:10 A>C",
+    }
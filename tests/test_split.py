@@ -0,0 +1,59 @@
+import os
+import gzip
+import shutil
+
+from ..matrix_table_consumer import vcf_tools
+
+
+def test_split() -> None:
+    vcf = "./data/filter/test3.vcf"
+    output_dir = "./data/test_split_output"
+
+    manifest = vcf_tools.split(vcf_path=vcf, output_dir=output_dir)
+
+    files = [(file["name"], file["records"]) for file in manifest["files"]]
+    with open(os.path.join(output_dir, "chr4.vcf"), "r") as file:
+        lines = file.read().splitlines()
+
+    shutil.rmtree(output_dir)
+
+    assert files == [("chr1", 16), ("chr2", 2), ("chr3", 1), ("chr4", 3), ("chr5", 1), ("chr6", 3)]
+    assert lines[-1] == "chr4\t9\t.\tC\tT\t9\tPASS\t.\tGT\t./.\t0/1"
+    assert sum(1 for line in lines if line.startswith("##")) == 17
+
+
+def test_split_by_sample() -> None:
+    vcf = "./data/filter/test3.vcf"
+    output_dir = "./data/test_split_output"
+
+    manifest = vcf_tools.split(vcf_path=vcf, output_dir=output_dir, by_sample=True, bgzip=True)
+
+    paths = [file["path"] for file in manifest["files"]]
+    with gzip.open(os.path.join(output_dir, "tumor.vcf.gz"), "rt") as file:
+        lines = [line for line in file.read().splitlines() if not line.startswith("##")]
+
+    shutil.rmtree(output_dir)
+
+    assert [os.path.basename(path) for path in paths] == ["HG00096.vcf.gz", "tumor.vcf.gz"]
+    assert lines[0].split("\t")[8:] == ["FORMAT", "tumor"]
+    assert len(lines) == 27
+
+
+def test_split_max_open_files() -> None:
+    # With one open file the contig outputs are closed and opened again and the samples
+    # are written in one pass each, the outputs must not change
+    vcf = "./data/filter/test3.vcf"
+
+    def read_outputs(output_dir: str, **options) -> list[tuple[str, int, str]]:
+        manifest = vcf_tools.split(vcf_path=vcf, output_dir=output_dir, bgzip=True, **options)
+        outputs = []
+        for file in manifest["files"]:
+            with gzip.open(file["path"], "rt") as output_file:
+                outputs.append((file["name"], file["records"], output_file.read()))
+        shutil.rmtree(output_dir)
+        return outputs
+
+    for by_sample in [False, True]:
+        expected = read_outputs("./data/test_split_output", by_sample=by_sample)
+        outputs = read_outputs("./data/test_split_output", by_sample=by_sample, max_open_files=1)
+        assert outputs == expected
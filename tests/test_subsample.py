@@ -0,0 +1,76 @@
+import os
+
+from ..matrix_table_consumer import vcf_tools
+
+
+def test_subsample_count() -> None:
+    vcf = "./data/filter/test3.vcf"
+    output_vcf = "./data/filter/test_subsample_output.vcf"
+
+    outputs = []
+    for _ in range(2):
+        report = vcf_tools.subsample(vcf_path=vcf, output_vcf=output_vcf, count=5, seed=7)
+        with open(output_vcf, "r") as file:
+            outputs.append(file.read())
+        os.remove(output_vcf)
+
+    with open(vcf, "r") as file:
+        records = [line for line in file.read().splitlines(keepends=True) if not line.startswith("#")]
+    sampled = [line for line in outputs[0].splitlines(keepends=True) if not line.startswith("#")]
+
+    assert report["input_rows"] == 26
+    assert report["output_rows"] == 5
+    assert outputs[0] == outputs[1]
+    assert sampled == [line for line in records if line in sampled]
+
+
+def test_subsample_thin() -> None:
+    vcf = "./data/sort/test_sorted.vcf"
+    output_vcf = "./data/sort/test_subsample_output.vcf"
+
+    report = vcf_tools.subsample(vcf_path=vcf, output_vcf=output_vcf, thin_distance=5)
+
+    with open(output_vcf, "r") as file:
+        records = [line.split("\t")[:2] for line in file if not line.startswith("#")]
+    os.remove(output_vcf)
+
+    assert report["output_rows"] == len(records)
+    for previous, current in zip(records, records[1:]):
+        assert previous[0] != current[0] or int(current[1]) - int(previous[1]) >= 5
+
+
+def test_subsample_fraction() -> None:
+    vcf = "./data/filter/test3.vcf"
+    output_vcf = "./data/filter/test_subsample_output.vcf"
+
+    def sampled_records(seed: int) -> tuple[dict, list[str]]:
+        report = vcf_tools.subsample(vcf_path=vcf, output_vcf=output_vcf, fraction=0.5, seed=seed)
+        with open(output_vcf, "r") as file:
+            records = [line for line in file if not line.startswith("#")]
+        os.remove(output_vcf)
+        return report, records
+
+    with open(vcf, "r") as file:
+        records = [line for line in file if not line.startswith("#")]
+
+    report, sampled = sampled_records(seed=7)
+
+    assert report["input_rows"] == 26
+    assert report["output_rows"] == len(sampled)
+    assert 0 < len(sampled) < 26
+    assert sampled == [line for line in records if line in sampled]
+    assert sampled_records(seed=7) == (report, sampled)
+
+    # Seeds are 64-bit, seeds that differ only above 32 bits give different samples
+    assert sampled_records(seed=2**40) != sampled_records(seed=2**40 + 2**32)
+
+
+def test_subsample_thin_unsorted() -> None:
+    # The positions of each contig are sorted, but the records of chr1 are not together
+    vcf = "./data/subsample/interleaved.vcf"
+    output_vcf = "./data/subsample/test_subsample_output.vcf"
+
+    report = vcf_tools.subsample(vcf_path=vcf, output_vcf=output_vcf, thin_distance=5)
+
+    assert report["error"] == "Error thinning: input is not sorted: chr1:30 after chr2:50, the records of chr1 are not together"
+    assert not os.path.exists(output_vcf)